@@ -0,0 +1,263 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	_ Cache[int, int] = &arc[int, int]{}
+)
+
+// arc is a thread-safe fixed size Adaptive Replacement Cache (ARC).
+// It tracks both recency and frequency, and keeps ghost lists of recently
+// evicted keys to adapt the balance between the two to the workload.
+type arc[K comparable, V any] struct {
+	size int
+	p    int // target size of t1
+
+	t1 *cLRU[K, V]        // recently used once
+	b1 *cLRU[K, struct{}] // ghosts evicted from t1
+	t2 *cLRU[K, V]        // used at least twice
+	b2 *cLRU[K, struct{}] // ghosts evicted from t2
+
+	evicted *evictBuffer[K, V]
+	sync.RWMutex
+}
+
+// NewARC constructs a fixed size ARC cache with the given eviction
+// callback.
+func NewARC[K comparable, V any](size int, onEvicted func(key K, value V)) (Cache[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	c := &arc[K, V]{
+		size:    size,
+		evicted: newEvictBuffer(onEvicted),
+	}
+	// t1 and t2 never evict on their own, replace decides between them
+	c.t1, _ = NewcLRU(size, c.evicted.add)
+	c.t2, _ = NewcLRU(size, c.evicted.add)
+	c.b1, _ = NewcLRU[K, struct{}](size, nil)
+	c.b2, _ = NewcLRU[K, struct{}](size, nil)
+	return c, nil
+}
+
+// Add adds a value to the cache. Returns true if an eviction occurred.
+func (c *arc[K, V]) Add(key K, value V) (evicted bool) {
+	c.Lock()
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// add adds a value, adapting p on ghost hits.
+func (c *arc[K, V]) add(key K, value V) (evicted bool) {
+	// a second use promotes to t2
+	if _, ok := c.t1.detach(key); ok {
+		c.t2.add(key, value)
+		return false
+	}
+	if c.t2.contains(key) {
+		c.t2.add(key, value)
+		return false
+	}
+
+	// a ghost hit in b1 means t1 is too small
+	if c.b1.contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.len(), c.b2.len(); b2Len > b1Len {
+			delta = b2Len / b1Len
+		}
+		c.p += delta
+		if c.p > c.size {
+			c.p = c.size
+		}
+		if c.t1.len()+c.t2.len() >= c.size {
+			evicted = c.replace(false)
+		}
+		c.b1.remove(key)
+		c.t2.add(key, value)
+		return evicted
+	}
+
+	// a ghost hit in b2 means t2 is too small
+	if c.b2.contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.len(), c.b2.len(); b1Len > b2Len {
+			delta = b1Len / b2Len
+		}
+		c.p -= delta
+		if c.p < 0 {
+			c.p = 0
+		}
+		if c.t1.len()+c.t2.len() >= c.size {
+			evicted = c.replace(true)
+		}
+		c.b2.remove(key)
+		c.t2.add(key, value)
+		return evicted
+	}
+
+	// a new key, make room and keep the ghost lists trim
+	if c.t1.len()+c.t2.len() >= c.size {
+		evicted = c.replace(false)
+	}
+	if c.b1.len() > c.size-c.p {
+		c.b1.removeOldest()
+	}
+	if c.b2.len() > c.p {
+		c.b2.removeOldest()
+	}
+	c.t1.add(key, value)
+	return evicted
+}
+
+// replace evicts from t1 or t2 depending on p, remembering the key in the
+// matching ghost list. Returns true if an eviction occurred.
+func (c *arc[K, V]) replace(b2ContainsKey bool) bool {
+	t1Len := c.t1.len()
+	if t1Len > 0 && (t1Len > c.p || (t1Len == c.p && b2ContainsKey)) {
+		if k, _, ok := c.t1.removeOldest(); ok {
+			c.b1.add(k, struct{}{})
+			return true
+		}
+		return false
+	}
+	if k, _, ok := c.t2.removeOldest(); ok {
+		c.b2.add(k, struct{}{})
+		return true
+	}
+	return false
+}
+
+// Get looks up a key's value from the cache.
+func (c *arc[K, V]) Get(key K) (value V, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	// a second use promotes to t2
+	if value, ok = c.t1.detach(key); ok {
+		c.t2.add(key, value)
+		return value, true
+	}
+	return c.t2.get(key)
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness or deleting it for being stale.
+func (c *arc[K, V]) Contains(key K) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.t1.contains(key) || c.t2.contains(key)
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *arc[K, V]) Peek(key K) (value V, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	if value, ok = c.t1.peek(key); ok {
+		return value, true
+	}
+	return c.t2.peek(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *arc[K, V]) Remove(key K) (present bool) {
+	c.Lock()
+	present = c.t1.remove(key) || c.t2.remove(key)
+	c.b1.remove(key)
+	c.b2.remove(key)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return present
+}
+
+// Keys returns a slice of the keys in the cache, the recently used ones
+// first and then the frequently used ones, each from oldest to newest.
+func (c *arc[K, V]) Keys() []K {
+	c.RLock()
+	defer c.RUnlock()
+	return append(c.t1.keys(), c.t2.keys()...)
+}
+
+// Len returns the number of items in the cache.
+func (c *arc[K, V]) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.t1.len() + c.t2.len()
+}
+
+// Purge is used to completely clear the cache.
+func (c *arc[K, V]) Purge() {
+	c.Lock()
+	c.t1.purge()
+	c.t2.purge()
+	c.b1.purge()
+	c.b2.purge()
+	c.p = 0
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+}
+
+// Resize changes the cache size, returning the number evicted.
+func (c *arc[K, V]) Resize(size int) (evicted int) {
+	if size <= 0 {
+		return 0
+	}
+	c.Lock()
+	c.size = size
+	if c.p > size {
+		c.p = size
+	}
+	for c.t1.len()+c.t2.len() > size && c.replace(false) {
+		evicted++
+	}
+	c.t1.resize(size)
+	c.t2.resize(size)
+	c.b1.resize(size)
+	c.b2.resize(size)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// ContainsOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *arc[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
+	c.Lock()
+	if c.t1.contains(key) || c.t2.contains(key) {
+		c.Unlock()
+		return true, false
+	}
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return false, evicted
+}
+
+// PeekOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *arc[K, V]) PeekOrAdd(key K, value V) (previous V, ok, evicted bool) {
+	c.Lock()
+	if previous, ok = c.t1.peek(key); !ok {
+		previous, ok = c.t2.peek(key)
+	}
+	if ok {
+		c.Unlock()
+		return previous, true, false
+	}
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
@@ -0,0 +1,130 @@
+package lru
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Event is an entry that left the cache and why.
+	Event[K comparable, V any] struct {
+		Key    K
+		Value  V
+		Reason EvictReason
+	}
+
+	// DeliveryPolicy decides what happens to an event when a
+	// subscriber's channel is full.
+	DeliveryPolicy int
+
+	// eventHub fans evictions out to subscribers.
+	// The zero value is ready to use.
+	eventHub[K comparable, V any] struct {
+		mu   sync.Mutex
+		subs []*subscriber[K, V]
+		n    atomic.Int32
+	}
+
+	// subscriber is one Subscribe channel.
+	subscriber[K comparable, V any] struct {
+		ch     chan Event[K, V]
+		policy DeliveryPolicy
+		done   chan struct{}
+		mu     sync.RWMutex
+		closed bool
+	}
+)
+
+const (
+	unknownDelivery DeliveryPolicy = iota
+	// DropWhenFull discards events a subscriber has no room for.
+	DropWhenFull
+	// BlockWhenFull makes the evicting call wait for the subscriber.
+	// The cache lock is not held while waiting.
+	BlockWhenFull
+)
+
+// active reports whether there are subscribers.
+func (h *eventHub[K, V]) active() bool {
+	return h.n.Load() > 0
+}
+
+// subscribe adds a subscriber with a channel buffered by size.
+func (h *eventHub[K, V]) subscribe(size int, policy DeliveryPolicy) (<-chan Event[K, V], func()) {
+	if size < 0 {
+		size = 0
+	}
+	if policy != BlockWhenFull {
+		policy = DropWhenFull
+	}
+	s := &subscriber[K, V]{
+		ch:     make(chan Event[K, V], size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subs = append(h.subs, s)
+	h.n.Add(1)
+	h.mu.Unlock()
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			h.unsubscribe(s)
+		})
+	}
+}
+
+// unsubscribe removes s and closes its channel once no publish is
+// sending to it.
+func (h *eventHub[K, V]) unsubscribe(s *subscriber[K, V]) {
+	h.mu.Lock()
+	for i, sub := range h.subs {
+		if sub == s {
+			h.subs = append(h.subs[:i:i], h.subs[i+1:]...)
+			h.n.Add(-1)
+			break
+		}
+	}
+	h.mu.Unlock()
+	// release a blocked publish, then wait for it to finish
+	close(s.done)
+	s.mu.Lock()
+	s.closed = true
+	close(s.ch)
+	s.mu.Unlock()
+}
+
+// publish sends evictions to every subscriber.
+func (h *eventHub[K, V]) publish(ks []K, vs []V, rs []EvictReason) {
+	if !h.active() {
+		return
+	}
+	h.mu.Lock()
+	subs := h.subs
+	h.mu.Unlock()
+	for _, s := range subs {
+		for i := range ks {
+			s.send(Event[K, V]{Key: ks[i], Value: vs[i], Reason: rs[i]})
+		}
+	}
+}
+
+// send delivers e according to the subscriber's policy.
+func (s *subscriber[K, V]) send(e Event[K, V]) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	if s.policy == BlockWhenFull {
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+	}
+}
@@ -0,0 +1,51 @@
+package lru
+
+// evictBuffer saves evicted key/vals while a cache holds its lock, so the
+// externally registered callback can run outside of the critical section.
+type evictBuffer[K comparable, V any] struct {
+	keys      []K
+	vals      []V
+	onEvicted func(key K, value V)
+}
+
+// newEvictBuffer returns a buffer for onEvicted, which may be nil.
+func newEvictBuffer[K comparable, V any](onEvicted func(key K, value V)) *evictBuffer[K, V] {
+	b := &evictBuffer[K, V]{onEvicted: onEvicted}
+	if onEvicted != nil {
+		b.init()
+	}
+	return b
+}
+
+func (b *evictBuffer[K, V]) init() {
+	b.keys = make([]K, 0, defaultEvictedBufferSize)
+	b.vals = make([]V, 0, defaultEvictedBufferSize)
+}
+
+// add saves an evicted key/val, it is the onEvict of the inner lists.
+func (b *evictBuffer[K, V]) add(k K, v V) {
+	if b.onEvicted == nil {
+		return
+	}
+	b.keys = append(b.keys, k)
+	b.vals = append(b.vals, v)
+}
+
+// take hands over the saved key/vals and resets the buffer,
+// it must be called with the cache lock held.
+func (b *evictBuffer[K, V]) take() (ks []K, vs []V) {
+	if len(b.keys) == 0 {
+		return nil, nil
+	}
+	ks, vs = b.keys, b.vals
+	b.init()
+	return ks, vs
+}
+
+// call invokes the callback for key/vals returned by take,
+// it must be called without the cache lock held.
+func (b *evictBuffer[K, V]) call(ks []K, vs []V) {
+	for i := 0; i < len(ks); i++ {
+		b.onEvicted(ks[i], vs[i])
+	}
+}
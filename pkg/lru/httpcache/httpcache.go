@@ -0,0 +1,394 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbluth/go/pkg/lru"
+)
+
+const (
+	// HeaderCache tells whether a response came from the cache
+	HeaderCache = "X-Cache"
+	// Hit and Miss are the values of HeaderCache
+	Hit  = "HIT"
+	Miss = "MISS"
+)
+
+var (
+	_ Cache = &cache{}
+
+	errSize = fmt.Errorf("httpcache: must provide a positive size")
+)
+
+type (
+	// Cache caches GET responses of a handler in an lru bounded by the
+	// size of the cached responses, and answers HEAD requests from them.
+	Cache interface {
+		// Handler wraps next, answering from the cache when it can
+		Handler(next http.Handler) http.Handler
+		// Purge drops every cached response
+		Purge()
+		// Stats returns the counters of the underlying lru
+		Stats() lru.Stats
+		// Stop stops the background work of the underlying lru
+		Stop()
+	}
+	// Config configures a Cache.
+	Config struct {
+		// Size is the maximum number of bytes of cached responses,
+		// counting their url, headers and body
+		Size int
+		// DefaultMaxAge is used for responses without a max-age,
+		// zero only caches responses that have one
+		DefaultMaxAge time.Duration
+	}
+	cache struct {
+		lru           lru.LRU[string, *response]
+		size          int
+		defaultMaxAge time.Duration
+	}
+	// response is a cached response, or the Vary header names of a url
+	// when vary is set
+	response struct {
+		header http.Header
+		body   []byte
+		etag   string
+		stored time.Time
+		vary   []string
+	}
+)
+
+// New returns a response cache.
+func New(cfg Config) (Cache, error) {
+	if cfg.Size <= 0 {
+		return nil, errSize
+	}
+	l, err := lru.NewWithConfig(lru.Config[string, *response]{
+		Size:    cfg.Size,
+		Weigher: weigh,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cache{
+		lru:           l,
+		size:          cfg.Size,
+		defaultMaxAge: cfg.DefaultMaxAge,
+	}, nil
+}
+
+// weigh returns the approximate size of a cached response.
+func weigh(key string, r *response) int {
+	n := len(key) + len(r.body) + len(r.etag)
+	for k, vs := range r.header {
+		for _, v := range vs {
+			n += len(k) + len(v)
+		}
+	}
+	for _, v := range r.vary {
+		n += len(v)
+	}
+	return n
+}
+
+func (c *cache) Purge() {
+	c.lru.Purge()
+}
+
+func (c *cache) Stats() lru.Stats {
+	return c.lru.Stats()
+}
+
+func (c *cache) Stop() {
+	c.lru.Stop()
+}
+
+func (c *cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, has := reqCC["no-store"]; has {
+			next.ServeHTTP(w, r)
+			return
+		}
+		base := baseKey(r)
+		_, noCache := reqCC["no-cache"]
+		if age, has := reqCC["max-age"]; has && age == "0" {
+			noCache = true
+		}
+		if !noCache {
+			if resp, ok := c.lookup(base, r); ok {
+				resp.write(w, r, Hit)
+				return
+			}
+		}
+		// a handler may leave out the body of a HEAD response, which would
+		// not do for a GET and would change the etag
+		if r.Method == http.MethodHead {
+			w.Header().Set(HeaderCache, Miss)
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.miss(w, r, next, base)
+	})
+}
+
+// lookup returns the cached response for a request.
+func (c *cache) lookup(base string, r *http.Request) (*response, bool) {
+	v, ok := c.lru.Get(base)
+	if !ok {
+		return nil, false
+	}
+	if v.vary == nil {
+		return v, true
+	}
+	return c.lru.Get(varyKey(base, v.vary, r))
+}
+
+// miss serves a request with next, storing the response if it may be
+// cached. Conditional headers are left to the cache, so next always
+// answers with the full response.
+func (c *cache) miss(w http.ResponseWriter, r *http.Request, next http.Handler, base string) {
+	req := r.Clone(r.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	w.Header().Set(HeaderCache, Miss)
+	rec := &recorder{w: w, limit: c.size}
+	next.ServeHTTP(rec, req)
+	if rec.passthrough {
+		return
+	}
+	h := w.Header().Clone()
+	h.Del(HeaderCache)
+	resp := &response{
+		header: h,
+		body:   rec.body.Bytes(),
+		etag:   h.Get("ETag"),
+		stored: time.Now(),
+	}
+	if resp.etag == "" {
+		sum := sha256.Sum256(resp.body)
+		resp.etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+		resp.header.Set("ETag", resp.etag)
+	}
+	if ttl, ok := c.ttl(r, resp.header); ok {
+		c.store(base, r, resp, ttl)
+	}
+	resp.write(w, r, Miss)
+}
+
+// ttl returns how long a response may be cached, false if not at all.
+func (c *cache) ttl(r *http.Request, h http.Header) (time.Duration, bool) {
+	if h.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	for _, name := range varyHeaders(h) {
+		if name == "*" {
+			return 0, false
+		}
+	}
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, has := cc[d]; has {
+			return 0, false
+		}
+	}
+	_, public := cc["public"]
+	maxAge, has := cc["s-maxage"]
+	if has {
+		public = true
+	} else {
+		maxAge, has = cc["max-age"]
+	}
+	// a shared cache must not hand out authenticated responses
+	if r.Header.Get("Authorization") != "" && !public {
+		return 0, false
+	}
+	if !has {
+		return c.defaultMaxAge, c.defaultMaxAge > 0
+	}
+	secs, err := strconv.Atoi(maxAge)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// store caches a response, under its Vary variant if it has one. The
+// recorder only bounds the body, so a response whose headers take it over
+// the size is not stored.
+func (c *cache) store(base string, r *http.Request, resp *response, ttl time.Duration) {
+	vary := varyHeaders(resp.header)
+	if len(vary) == 0 {
+		if weigh(base, resp) <= c.size {
+			c.lru.AddWithTTL(base, resp, ttl)
+		}
+		return
+	}
+	key := varyKey(base, vary, r)
+	if weigh(key, resp) > c.size {
+		return
+	}
+	c.lru.AddWithTTL(base, &response{vary: vary, stored: resp.stored}, ttl)
+	c.lru.AddWithTTL(key, resp, ttl)
+}
+
+// write sends a response, or 304 Not Modified if the request already
+// has it.
+func (resp *response) write(w http.ResponseWriter, r *http.Request, status string) {
+	h := w.Header()
+	for k, vs := range resp.header {
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set(HeaderCache, status)
+	if status == Hit {
+		h.Set("Age", strconv.Itoa(int(time.Since(resp.stored).Seconds())))
+	}
+	if etagMatch(r.Header.Get("If-None-Match"), resp.etag) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(resp.body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp.body)
+}
+
+// baseKey is the url of a request, HEAD requests share the key of GET.
+func baseKey(r *http.Request) string {
+	return http.MethodGet + " " + r.Host + r.URL.RequestURI()
+}
+
+// varyKey adds the values of the vary headers of a request to base.
+func varyKey(base string, vary []string, r *http.Request) string {
+	b := strings.Builder{}
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// varyHeaders returns the sorted header names a response varies on.
+func varyHeaders(h http.Header) []string {
+	vary := []string{}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+	return vary
+}
+
+// parseCacheControl returns the directives of a Cache-Control header.
+func parseCacheControl(v string) map[string]string {
+	cc := map[string]string{}
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(d, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
+
+// etagMatch reports whether an If-None-Match header matches etag,
+// comparing weakly as RFC 9110 asks for If-None-Match.
+func etagMatch(inm, etag string) bool {
+	if inm == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder buffers a 200 response of up to limit bytes so it can be
+// cached, and passes anything else through to w.
+type recorder struct {
+	w           http.ResponseWriter
+	body        bytes.Buffer
+	limit       int
+	status      int
+	passthrough bool
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.w.Header()
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	if status != http.StatusOK {
+		rec.pass()
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.passthrough && rec.body.Len()+len(p) > rec.limit {
+		rec.pass()
+	}
+	if rec.passthrough {
+		return rec.w.Write(p)
+	}
+	return rec.body.Write(p)
+}
+
+// Flush gives up on caching and streams the response.
+func (rec *recorder) Flush() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.pass()
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// pass writes what was buffered and stops buffering.
+func (rec *recorder) pass() {
+	if rec.passthrough {
+		return
+	}
+	rec.passthrough = true
+	rec.w.WriteHeader(rec.status)
+	if rec.body.Len() > 0 {
+		rec.w.Write(rec.body.Bytes())
+		rec.body.Reset()
+	}
+}
@@ -0,0 +1,208 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// origin counts calls and answers with the path, headers set by query
+func origin(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if r.Header.Get("If-None-Match") != "" {
+			panic("conditional headers should not reach the origin")
+		}
+		q := r.URL.Query()
+		if cc := q.Get("cc"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if v := q.Get("vary"); v != "" {
+			w.Header().Set("Vary", v)
+		}
+		if e := q.Get("etag"); e != "" {
+			w.Header().Set("ETag", e)
+		}
+		if s := q.Get("status"); s != "" {
+			var code int
+			fmt.Sscan(s, &code)
+			w.WriteHeader(code)
+		}
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, r.Header.Get("Accept-Language"), q.Get("body"))
+	})
+}
+
+// do sends a request through h
+func do(h http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCache(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Fatalf("should fail with size 0")
+	}
+	c, err := New(Config{Size: 1 << 16})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Stop()
+	calls := 0
+	h := c.Handler(origin(&calls))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header []string
+		status int
+		cache  string
+		calls  int
+	}{
+		{"miss", "GET", "/a?cc=max-age=60", nil, 200, Miss, 1},
+		{"hit", "GET", "/a?cc=max-age=60", nil, 200, Hit, 1},
+		{"head hits the get", "HEAD", "/a?cc=max-age=60", nil, 200, Hit, 1},
+		{"head miss", "HEAD", "/i?cc=max-age=60", nil, 200, Miss, 2},
+		{"head miss is not cached", "HEAD", "/i?cc=max-age=60", nil, 200, Miss, 3},
+		{"request no-cache", "GET", "/a?cc=max-age=60", []string{"Cache-Control", "no-cache"}, 200, Miss, 4},
+		{"request no-store", "GET", "/a?cc=max-age=60", []string{"Cache-Control", "no-store"}, 200, "", 5},
+		{"no max-age", "GET", "/b", nil, 200, Miss, 6},
+		{"no max-age again", "GET", "/b", nil, 200, Miss, 7},
+		{"no-store", "GET", "/c?cc=no-store", nil, 200, Miss, 8},
+		{"no-store again", "GET", "/c?cc=no-store", nil, 200, Miss, 9},
+		{"private", "GET", "/d?cc=private,max-age=60", nil, 200, Miss, 10},
+		{"private again", "GET", "/d?cc=private,max-age=60", nil, 200, Miss, 11},
+		{"error", "GET", "/e?cc=max-age=60&status=500", nil, 500, Miss, 12},
+		{"error again", "GET", "/e?cc=max-age=60&status=500", nil, 500, Miss, 13},
+		{"post", "POST", "/a?cc=max-age=60", nil, 200, "", 14},
+		{"authorized", "GET", "/f?cc=max-age=60", []string{"Authorization", "x"}, 200, Miss, 15},
+		{"authorized again", "GET", "/f?cc=max-age=60", []string{"Authorization", "x"}, 200, Miss, 16},
+		{"authorized public", "GET", "/f?cc=public,max-age=60", []string{"Authorization", "x"}, 200, Miss, 17},
+		{"authorized public again", "GET", "/f?cc=public,max-age=60", []string{"Authorization", "x"}, 200, Hit, 17},
+		{"vary en", "GET", "/g?cc=max-age=60&vary=Accept-Language", []string{"Accept-Language", "en"}, 200, Miss, 18},
+		{"vary de", "GET", "/g?cc=max-age=60&vary=Accept-Language", []string{"Accept-Language", "de"}, 200, Miss, 19},
+		{"vary en again", "GET", "/g?cc=max-age=60&vary=Accept-Language", []string{"Accept-Language", "en"}, 200, Hit, 19},
+		{"vary de again", "GET", "/g?cc=max-age=60&vary=Accept-Language", []string{"Accept-Language", "de"}, 200, Hit, 19},
+		{"vary star", "GET", "/h?cc=max-age=60&vary=*", nil, 200, Miss, 20},
+		{"vary star again", "GET", "/h?cc=max-age=60&vary=*", nil, 200, Miss, 21},
+	}
+	for _, tt := range tests {
+		w := do(h, tt.method, tt.target, tt.header...)
+		if w.Code != tt.status || w.Header().Get(HeaderCache) != tt.cache || calls != tt.calls {
+			t.Fatalf("%s: bad response: %v %q, calls %v", tt.name, w.Code, w.Header().Get(HeaderCache), calls)
+		}
+		if tt.method == "GET" && tt.status == 200 {
+			want := strings.SplitN(tt.target, "?", 2)[0] + " "
+			if len(tt.header) == 2 && tt.header[0] == "Accept-Language" {
+				want += tt.header[1]
+			}
+			if body, _ := io.ReadAll(w.Body); string(body) != want+" " {
+				t.Fatalf("%s: bad body: %q", tt.name, body)
+			}
+		}
+	}
+	if s := c.Stats(); s.Hits == 0 || s.Cost == 0 {
+		t.Fatalf("bad stats: %+v", s)
+	}
+	c.Purge()
+	if w := do(h, "GET", "/a?cc=max-age=60"); w.Header().Get(HeaderCache) != Miss {
+		t.Fatalf("purge should drop responses")
+	}
+}
+
+func TestCache_ETag(t *testing.T) {
+	c, err := New(Config{Size: 1 << 16, DefaultMaxAge: time.Minute})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Stop()
+	calls := 0
+	h := c.Handler(origin(&calls))
+
+	// an etag is made for responses without one
+	w := do(h, "GET", "/a?body=x")
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Code != 200 {
+		t.Fatalf("should have an etag: %v", w.Header())
+	}
+	if do(h, "GET", "/a?body=y").Header().Get("ETag") == etag {
+		t.Fatalf("etags should differ")
+	}
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w = do(h, "GET", "/a?body=x", "If-None-Match", inm)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Fatalf("%s: should not be modified: %v", inm, w.Code)
+		}
+	}
+	w = do(h, "GET", "/a?body=x", "If-None-Match", `"other"`)
+	if w.Code != 200 || w.Header().Get(HeaderCache) != Hit {
+		t.Fatalf("should be a hit: %v", w.Code)
+	}
+	// a HEAD request gets the etag of the GET
+	w = do(h, "HEAD", "/a?body=x")
+	if w.Header().Get("ETag") != etag || w.Header().Get(HeaderCache) != Hit || w.Body.Len() != 0 {
+		t.Fatalf("head should have the etag of the get: %v", w.Header())
+	}
+	// the origin etag is kept, and answered on a miss too
+	w = do(h, "GET", `/b?etag="v1"`, "If-None-Match", `"v1"`)
+	if w.Code != http.StatusNotModified || w.Header().Get(HeaderCache) != Miss {
+		t.Fatalf("should not be modified: %v", w.Code)
+	}
+	if calls != 3 {
+		t.Fatalf("bad calls: %v", calls)
+	}
+}
+
+func TestCache_Size(t *testing.T) {
+	c, err := New(Config{Size: 1024, DefaultMaxAge: time.Minute})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Stop()
+	calls := 0
+	h := c.Handler(origin(&calls))
+
+	// a response larger than the cache is streamed and not cached
+	big := strings.Repeat("x", 2048)
+	for i := 0; i < 2; i++ {
+		w := do(h, "GET", "/big?body="+big)
+		if w.Code != 200 || w.Body.Len() != len("/big  ")+len(big) {
+			t.Fatalf("bad response: %v %v", w.Code, w.Body.Len())
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("large response should not be cached: %v", calls)
+	}
+	// a body that fits but not with its headers does not flush the cache
+	do(h, "GET", "/small?body=x")
+	near := "/near?body=" + big[:1024-len("/near  ")]
+	do(h, "GET", near)
+	if w := do(h, "GET", near); w.Header().Get(HeaderCache) != Miss || w.Body.Len() != 1024 {
+		t.Fatalf("response over the size should not be cached: %v", w.Body.Len())
+	}
+	if w := do(h, "GET", "/small?body=x"); w.Header().Get(HeaderCache) != Hit {
+		t.Fatalf("other responses should stay cached")
+	}
+	// the cost of the cached responses stays under the size
+	for i := 0; i < 100; i++ {
+		do(h, "GET", fmt.Sprintf("/%d?body=%s", i, big[:100]))
+		if cost := c.Stats().Cost; cost > 1024 {
+			t.Fatalf("cache too large: %v", cost)
+		}
+	}
+	if w := do(h, "GET", "/99?body="+big[:100]); w.Header().Get(HeaderCache) != Hit {
+		t.Fatalf("newest response should be cached")
+	}
+	if w := do(h, "GET", "/0?body="+big[:100]); w.Header().Get(HeaderCache) != Miss {
+		t.Fatalf("oldest response should be evicted")
+	}
+}
@@ -0,0 +1,404 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	_ LFU[int, int] = &lfu[int, int]{}
+)
+
+// LFU is the interface for a least frequently used cache.
+type LFU[K comparable, V any] interface {
+	Cache[K, V]
+	// Removes the least frequently used entry from cache.
+	RemoveLeastFrequent() (K, V, bool)
+	// Returns the least frequently used entry. #key, value, isFound
+	GetLeastFrequent() (K, V, bool)
+	// Returns how often a key was used, zero if not found.
+	Frequency(key K) int
+}
+
+// lfu is a thread-safe fixed size LFU cache.
+type lfu[K comparable, V any] struct {
+	lfu     *cLFU[K, V]
+	evicted *evictBuffer[K, V]
+	sync.RWMutex
+}
+
+// NewLFU constructs a fixed size LFU cache with the given eviction
+// callback. When full it evicts the least frequently used entry, and the
+// least recently used one among entries used equally often.
+func NewLFU[K comparable, V any](size int, onEvicted func(key K, value V)) (LFU[K, V], error) {
+	c := &lfu[K, V]{
+		evicted: newEvictBuffer(onEvicted),
+	}
+	err := (error)(nil)
+	c.lfu, err = newcLFU(size, c.evicted.add)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Purge is used to completely clear the cache.
+func (c *lfu[K, V]) Purge() {
+	c.Lock()
+	c.lfu.purge()
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	// invoke callback outside of critical section
+	c.evicted.call(ks, vs)
+}
+
+// Add adds a value to the cache, counting as a use of the key.
+// Returns true if an eviction occurred.
+func (c *lfu[K, V]) Add(key K, value V) (evicted bool) {
+	c.Lock()
+	evicted = c.lfu.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// Get looks up a key's value from the cache, counting as a use of the key.
+func (c *lfu[K, V]) Get(key K) (value V, ok bool) {
+	c.Lock()
+	value, ok = c.lfu.get(key)
+	c.Unlock()
+	return value, ok
+}
+
+// Contains checks if a key is in the cache, without updating its
+// frequency.
+func (c *lfu[K, V]) Contains(key K) bool {
+	c.RLock()
+	containKey := c.lfu.contains(key)
+	c.RUnlock()
+	return containKey
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// its frequency.
+func (c *lfu[K, V]) Peek(key K) (value V, ok bool) {
+	c.RLock()
+	value, ok = c.lfu.peek(key)
+	c.RUnlock()
+	return value, ok
+}
+
+// ContainsOrAdd checks if a key is in the cache without updating its
+// frequency, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *lfu[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
+	c.Lock()
+	if c.lfu.contains(key) {
+		c.Unlock()
+		return true, false
+	}
+	evicted = c.lfu.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return false, evicted
+}
+
+// PeekOrAdd checks if a key is in the cache without updating its
+// frequency, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *lfu[K, V]) PeekOrAdd(key K, value V) (previous V, ok, evicted bool) {
+	c.Lock()
+	previous, ok = c.lfu.peek(key)
+	if ok {
+		c.Unlock()
+		return previous, true, false
+	}
+	evicted = c.lfu.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
+
+// Remove removes the provided key from the cache.
+func (c *lfu[K, V]) Remove(key K) (present bool) {
+	c.Lock()
+	present = c.lfu.remove(key)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
+
+// Resize changes the cache size.
+func (c *lfu[K, V]) Resize(size int) (evicted int) {
+	c.Lock()
+	evicted = c.lfu.resize(size)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// RemoveLeastFrequent removes the least frequently used item from the cache.
+func (c *lfu[K, V]) RemoveLeastFrequent() (key K, value V, ok bool) {
+	c.Lock()
+	key, value, ok = c.lfu.removeLeastFrequent()
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
+
+// GetLeastFrequent returns the least frequently used entry, the next to
+// be evicted.
+func (c *lfu[K, V]) GetLeastFrequent() (key K, value V, ok bool) {
+	c.RLock()
+	key, value, ok = c.lfu.getLeastFrequent()
+	c.RUnlock()
+	return
+}
+
+// Frequency returns how often a key was used, zero if not found.
+func (c *lfu[K, V]) Frequency(key K) int {
+	c.RLock()
+	freq := c.lfu.frequency(key)
+	c.RUnlock()
+	return freq
+}
+
+// Keys returns a slice of the keys in the cache, from least to most
+// frequently used.
+func (c *lfu[K, V]) Keys() []K {
+	c.RLock()
+	keys := c.lfu.keys()
+	c.RUnlock()
+	return keys
+}
+
+// Len returns the number of items in the cache.
+func (c *lfu[K, V]) Len() int {
+	c.RLock()
+	length := c.lfu.len()
+	c.RUnlock()
+	return length
+}
+
+// CORE
+////////////////////////////////////////////////////////////////////////
+
+// cLFU implements a non-thread safe fixed size LFU cache. Entries with the
+// same frequency share a bucket, an lruList ordered by recency, and the
+// buckets form a list ordered by frequency, so every operation is O(1).
+type cLFU[K comparable, V any] struct {
+	size    int
+	buckets freqBucket[K, V] // sentinel, buckets.next has the lowest frequency
+	items   map[K]lfuItem[K, V]
+	onEvict EvictCallback[K, V]
+}
+
+// freqBucket holds the entries used freq times.
+type freqBucket[K comparable, V any] struct {
+	freq       int
+	entries    lruList[K, V]
+	next, prev *freqBucket[K, V]
+}
+
+// lfuItem locates an entry and its bucket.
+type lfuItem[K comparable, V any] struct {
+	ent    *entry[K, V]
+	bucket *freqBucket[K, V]
+}
+
+// newcLFU constructs an LFU of the given size
+func newcLFU[K comparable, V any](size int, onEvict EvictCallback[K, V]) (*cLFU[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	c := &cLFU[K, V]{
+		size:    size,
+		items:   make(map[K]lfuItem[K, V]),
+		onEvict: onEvict,
+	}
+	c.buckets.next = &c.buckets
+	c.buckets.prev = &c.buckets
+	return c, nil
+}
+
+// purge is used to completely clear the cache.
+func (c *cLFU[K, V]) purge() {
+	for k, it := range c.items {
+		if c.onEvict != nil {
+			c.onEvict(k, it.ent.value)
+		}
+		delete(c.items, k)
+	}
+	c.buckets.next = &c.buckets
+	c.buckets.prev = &c.buckets
+}
+
+// add adds a value to the cache, counting a use of an existing key.
+// Returns true if an eviction occurred.
+func (c *cLFU[K, V]) add(key K, value V) (evicted bool) {
+	if it, ok := c.items[key]; ok {
+		it.ent.value = value
+		c.increment(key, it)
+		return false
+	}
+	if c.size <= 0 {
+		// nothing fits, so the new entry is evicted at once as in lru
+		if c.onEvict != nil {
+			c.onEvict(key, value)
+		}
+		return true
+	}
+	// make room first, so the new entry is not the one evicted
+	for len(c.items) >= c.size {
+		c.removeLeastFrequent()
+		evicted = true
+	}
+	b := c.buckets.next
+	if b == &c.buckets || b.freq != 1 {
+		b = c.insertBucket(1, &c.buckets)
+	}
+	ent := b.entries.pushFront(key, value)
+	c.items[key] = lfuItem[K, V]{ent: ent, bucket: b}
+	return evicted
+}
+
+// increment moves an entry to the bucket of the next frequency.
+func (c *cLFU[K, V]) increment(key K, it lfuItem[K, V]) {
+	b := it.bucket
+	next := b.next
+	if next == &c.buckets || next.freq != b.freq+1 {
+		next = c.insertBucket(b.freq+1, b)
+	}
+	b.entries.remove(it.ent)
+	next.entries.insert(it.ent, &next.entries.root)
+	c.items[key] = lfuItem[K, V]{ent: it.ent, bucket: next}
+	if b.entries.length() == 0 {
+		c.removeBucket(b)
+	}
+}
+
+// insertBucket adds an empty bucket for freq after at.
+func (c *cLFU[K, V]) insertBucket(freq int, at *freqBucket[K, V]) *freqBucket[K, V] {
+	b := &freqBucket[K, V]{freq: freq}
+	b.entries.init()
+	b.prev = at
+	b.next = at.next
+	at.next.prev = b
+	at.next = b
+	return b
+}
+
+// removeBucket unlinks an empty bucket.
+func (c *cLFU[K, V]) removeBucket(b *freqBucket[K, V]) {
+	b.prev.next = b.next
+	b.next.prev = b.prev
+	b.next = nil // avoid memory leaks
+	b.prev = nil // avoid memory leaks
+}
+
+// get looks up a key's value from the cache, counting a use.
+func (c *cLFU[K, V]) get(key K) (value V, ok bool) {
+	if it, ok := c.items[key]; ok {
+		c.increment(key, it)
+		return it.ent.value, true
+	}
+	return
+}
+
+// contains checks if a key is in the cache, without counting a use.
+func (c *cLFU[K, V]) contains(key K) (ok bool) {
+	_, ok = c.items[key]
+	return ok
+}
+
+// peek returns the key value (or undefined if not found) without
+// counting a use.
+func (c *cLFU[K, V]) peek(key K) (value V, ok bool) {
+	if it, ok := c.items[key]; ok {
+		return it.ent.value, true
+	}
+	return
+}
+
+// frequency returns how often a key was used, zero if not found.
+func (c *cLFU[K, V]) frequency(key K) int {
+	if it, ok := c.items[key]; ok {
+		return it.bucket.freq
+	}
+	return 0
+}
+
+// remove removes the provided key from the cache, returning if the
+// key was contained.
+func (c *cLFU[K, V]) remove(key K) (present bool) {
+	if it, ok := c.items[key]; ok {
+		c.removeItem(it)
+		return true
+	}
+	return false
+}
+
+// removeLeastFrequent removes the least recently used entry of the lowest
+// frequency, returning it.
+func (c *cLFU[K, V]) removeLeastFrequent() (key K, value V, ok bool) {
+	if b := c.buckets.next; b != &c.buckets {
+		ent := b.entries.back()
+		c.removeItem(lfuItem[K, V]{ent: ent, bucket: b})
+		return ent.key, ent.value, true
+	}
+	return
+}
+
+// getLeastFrequent returns the next entry to be evicted.
+func (c *cLFU[K, V]) getLeastFrequent() (key K, value V, ok bool) {
+	if b := c.buckets.next; b != &c.buckets {
+		ent := b.entries.back()
+		return ent.key, ent.value, true
+	}
+	return
+}
+
+// keys returns a slice of the keys in the cache, from least to most
+// frequently used and from oldest to newest within a frequency.
+func (c *cLFU[K, V]) keys() []K {
+	keys := make([]K, 0, len(c.items))
+	for b := c.buckets.next; b != &c.buckets; b = b.next {
+		for ent := b.entries.back(); ent != nil; ent = ent.prevEntry() {
+			keys = append(keys, ent.key)
+		}
+	}
+	return keys
+}
+
+// len returns the number of items in the cache.
+func (c *cLFU[K, V]) len() int {
+	return len(c.items)
+}
+
+// resize changes the cache size.
+func (c *cLFU[K, V]) resize(size int) (evicted int) {
+	for len(c.items) > size {
+		c.removeLeastFrequent()
+		evicted++
+	}
+	c.size = size
+	return evicted
+}
+
+// removeItem removes an entry from its bucket and the cache.
+func (c *cLFU[K, V]) removeItem(it lfuItem[K, V]) {
+	it.bucket.entries.remove(it.ent)
+	if it.bucket.entries.length() == 0 {
+		c.removeBucket(it.bucket)
+	}
+	delete(c.items, it.ent.key)
+	if c.onEvict != nil {
+		c.onEvict(it.ent.key, it.ent.value)
+	}
+}
@@ -0,0 +1,466 @@
+package lru
+
+import (
+	"fmt"
+	"testing"
+)
+
+// CORE
+////////
+
+func TestLFUCore(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k int, v int) {
+		if k != v {
+			t.Fatalf("Evict values not equal (%v!=%v)", k, v)
+		}
+		evictCounter++
+	}
+	l, err := newcLFU(128, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := newcLFU[int, int](0, nil); err == nil {
+		t.Fatalf("should fail with size 0")
+	}
+
+	for i := 0; i < 256; i++ {
+		l.add(i, i)
+	}
+	if l.len() != 128 {
+		t.Fatalf("bad len: %v", l.len())
+	}
+	if evictCounter != 128 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+	for i, k := range l.keys() {
+		if v, ok := l.peek(k); !ok || v != k || v != i+128 {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+
+	// 128..191 are used twice, 128..159 three times
+	for i := 128; i < 192; i++ {
+		l.get(i)
+	}
+	for i := 128; i < 160; i++ {
+		l.get(i)
+	}
+	if l.frequency(128) != 3 || l.frequency(160) != 2 || l.frequency(192) != 1 || l.frequency(0) != 0 {
+		t.Fatalf("bad frequencies: %v %v %v", l.frequency(128), l.frequency(160), l.frequency(192))
+	}
+	for i, k := range l.keys() {
+		if (i < 64 && k != i+192) || (i >= 64 && i < 96 && k != i+96) || (i >= 96 && k != i+32) {
+			t.Fatalf("out of order key %v: %v", i, k)
+		}
+	}
+
+	// the least frequent are evicted first
+	for i := 1000; i < 1064; i++ {
+		l.add(i, i)
+		l.get(i)
+		l.get(i)
+	}
+	for i := 192; i < 256; i++ {
+		if l.contains(i) {
+			t.Fatalf("%v should be evicted", i)
+		}
+	}
+	if k, _, _ := l.getLeastFrequent(); k != 160 {
+		t.Fatalf("bad least frequent: %v", k)
+	}
+
+	l.purge()
+	if l.len() != 0 || len(l.keys()) != 0 {
+		t.Fatalf("bad len: %v", l.len())
+	}
+	if _, ok := l.get(200); ok {
+		t.Fatalf("should contain nothing")
+	}
+	if _, _, ok := l.removeLeastFrequent(); ok {
+		t.Fatalf("should contain nothing")
+	}
+}
+
+// test that resize evicts the least frequent
+func TestLFU_Resize(t *testing.T) {
+	onEvictCounter := 0
+	onEvicted := func(k int, v int) {
+		onEvictCounter++
+	}
+	l, err := newcLFU(2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Downsize
+	l.add(1, 1)
+	l.add(2, 2)
+	l.get(1)
+	evicted := l.resize(1)
+	if evicted != 1 {
+		t.Errorf("1 element should have been evicted: %v", evicted)
+	}
+	if onEvictCounter != 1 {
+		t.Errorf("onEvicted should have been called 1 time: %v", onEvictCounter)
+	}
+	if !l.contains(1) || l.contains(2) {
+		t.Errorf("Element 2 should have been evicted")
+	}
+
+	// a new key replaces the least frequent
+	l.add(3, 3)
+	if l.contains(1) {
+		t.Errorf("Element 1 should have been evicted")
+	}
+
+	// Upsize
+	evicted = l.resize(2)
+	if evicted != 0 {
+		t.Errorf("0 elements should have been evicted: %v", evicted)
+	}
+
+	l.add(4, 4)
+	if !l.contains(3) || !l.contains(4) {
+		t.Errorf("Cache should have contained 2 elements")
+	}
+
+	// Size zero keeps nothing
+	onEvictCounter = 0
+	if evicted = l.resize(0); evicted != 2 || l.len() != 0 {
+		t.Errorf("2 elements should have been evicted: %v", evicted)
+	}
+	if !l.add(5, 5) || l.contains(5) || onEvictCounter != 3 {
+		t.Errorf("Element 5 should have been evicted: %v", onEvictCounter)
+	}
+}
+
+// MODULE
+////////////////////////////////////////////////////////////////
+
+func BenchmarkLFU_Rand(b *testing.B) {
+	l, err := NewLFU[int64, int64](8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		trace[i] = getRand(b) % 32768
+	}
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			if _, ok := l.Get(trace[i]); ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func BenchmarkLFU_Freq(b *testing.B) {
+	l, err := NewLFU[int64, int64](8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		if i%2 == 0 {
+			trace[i] = getRand(b) % 16384
+		} else {
+			trace[i] = getRand(b) % 32768
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Add(trace[i], trace[i])
+	}
+	var hit, miss int
+	for i := 0; i < b.N; i++ {
+		if _, ok := l.Get(trace[i]); ok {
+			hit++
+		} else {
+			miss++
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func TestLFU(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k int, v int) {
+		if k != v {
+			t.Fatalf("Evict values not equal (%v!=%v)", k, v)
+		}
+		evictCounter++
+	}
+	l, err := NewLFU(128, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 256; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 128 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+
+	if evictCounter != 128 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+
+	for i, k := range l.Keys() {
+		if v, ok := l.Get(k); !ok || v != k || v != i+128 {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+	for i := 0; i < 128; i++ {
+		if _, ok := l.Get(i); ok {
+			t.Fatalf("should be evicted")
+		}
+	}
+	for i := 128; i < 256; i++ {
+		if _, ok := l.Get(i); !ok {
+			t.Fatalf("should not be evicted")
+		}
+	}
+	for i := 128; i < 192; i++ {
+		l.Remove(i)
+		if _, ok := l.Get(i); ok {
+			t.Fatalf("should be deleted")
+		}
+	}
+
+	l.Get(192) // expect 192 to be last key in l.Keys()
+
+	for i, k := range l.Keys() {
+		if (i < 63 && k != i+193) || (i == 63 && k != 192) {
+			t.Fatalf("out of order key: %v", k)
+		}
+	}
+
+	l.Purge()
+	if l.Len() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if _, ok := l.Get(200); ok {
+		t.Fatalf("should contain nothing")
+	}
+}
+
+// test that Add returns true/false if an eviction occurred
+func TestLFUAdd(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k int, v int) {
+		evictCounter++
+	}
+
+	l, err := NewLFU(1, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if l.Add(1, 1) == true || evictCounter != 0 {
+		t.Errorf("should not have an eviction")
+	}
+	if l.Add(1, 1) == true || evictCounter != 0 {
+		t.Errorf("should not have an eviction")
+	}
+	if l.Add(2, 2) == false || evictCounter != 1 {
+		t.Errorf("should have an eviction")
+	}
+}
+
+// test that Contains doesn't update frequency
+func TestLFUContains(t *testing.T) {
+	l, err := NewLFU[int, int](2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if !l.Contains(1) {
+		t.Errorf("1 should be contained")
+	}
+
+	l.Add(3, 3)
+	if l.Contains(1) {
+		t.Errorf("Contains should not have updated frequency of 1")
+	}
+}
+
+// test that ContainsOrAdd doesn't update frequency
+func TestLFUContainsOrAdd(t *testing.T) {
+	l, err := NewLFU[int, int](2, func(key, value int) {})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	contains, evict := l.ContainsOrAdd(1, 1)
+	if !contains {
+		t.Errorf("1 should be contained")
+	}
+	if evict {
+		t.Errorf("nothing should be evicted here")
+	}
+
+	l.Add(3, 3)
+	contains, evict = l.ContainsOrAdd(1, 1)
+	if contains {
+		t.Errorf("1 should not have been contained")
+	}
+	if !evict {
+		t.Errorf("an eviction should have occurred")
+	}
+	if !l.Contains(1) {
+		t.Errorf("now 1 should be contained")
+	}
+}
+
+// test that PeekOrAdd doesn't update frequency
+func TestLFUPeekOrAdd(t *testing.T) {
+	l, err := NewLFU[int, int](2, func(key, value int) {})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	previous, contains, evict := l.PeekOrAdd(1, 1)
+	if !contains {
+		t.Errorf("1 should be contained")
+	}
+	if evict {
+		t.Errorf("nothing should be evicted here")
+	}
+	if previous != 1 {
+		t.Errorf("previous is not equal to 1")
+	}
+
+	l.Add(3, 3)
+	_, contains, evict = l.PeekOrAdd(1, 1)
+	if contains {
+		t.Errorf("1 should not have been contained")
+	}
+	if !evict {
+		t.Errorf("an eviction should have occurred")
+	}
+	if !l.Contains(1) {
+		t.Errorf("now 1 should be contained")
+	}
+}
+
+// test that Peek doesn't update frequency
+func TestLFUPeek(t *testing.T) {
+	l, err := NewLFU[int, int](2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	if v, ok := l.Peek(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+
+	l.Add(3, 3)
+	if l.Contains(1) {
+		t.Errorf("should not have updated frequency of 1")
+	}
+}
+
+// test that Resize can upsize and downsize
+func TestLFUResize(t *testing.T) {
+	onEvictCounter := 0
+	onEvicted := func(k int, v int) {
+		onEvictCounter++
+	}
+	l, err := NewLFU(2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Downsize
+	l.Add(1, 1)
+	l.Add(2, 2)
+	evicted := l.Resize(1)
+	if evicted != 1 {
+		t.Errorf("1 element should have been evicted: %v", evicted)
+	}
+	if onEvictCounter != 1 {
+		t.Errorf("onEvicted should have been called 1 time: %v", onEvictCounter)
+	}
+
+	l.Add(3, 3)
+	if l.Contains(1) {
+		t.Errorf("Element 1 should have been evicted")
+	}
+
+	// Upsize
+	evicted = l.Resize(2)
+	if evicted != 0 {
+		t.Errorf("0 elements should have been evicted: %v", evicted)
+	}
+
+	l.Add(4, 4)
+	if !l.Contains(3) || !l.Contains(4) {
+		t.Errorf("Cache should have contained 2 elements")
+	}
+}
+
+func TestLFU_GetLeastFrequent_RemoveLeastFrequent(t *testing.T) {
+	l, err := NewLFU[int, int](128, func(key, value int) {})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 256; i++ {
+		l.Add(i, i)
+	}
+	for i := 128; i < 130; i++ {
+		l.Get(i)
+	}
+	k, _, ok := l.GetLeastFrequent()
+	if !ok {
+		t.Fatalf("missing")
+	}
+	if k != 130 {
+		t.Fatalf("bad: %v", k)
+	}
+
+	k, _, ok = l.RemoveLeastFrequent()
+	if !ok {
+		t.Fatalf("missing")
+	}
+	if k != 130 {
+		t.Fatalf("bad: %v", k)
+	}
+
+	for i := 131; i < 256; i++ {
+		l.RemoveLeastFrequent()
+	}
+	k, _, ok = l.RemoveLeastFrequent()
+	if !ok {
+		t.Fatalf("missing")
+	}
+	if k != 128 {
+		t.Fatalf("bad: %v", k)
+	}
+	if l.Frequency(129) != 2 || fmt.Sprint(l.Keys()) != "[129]" {
+		t.Fatalf("bad keys: %v", l.Keys())
+	}
+}
@@ -0,0 +1,78 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errLoaderPanic = errors.New("lru: loader panicked")
+)
+
+// loadCall is an in-flight loader call shared by concurrent misses.
+type loadCall[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// loadGroup deduplicates concurrent loader calls for the same key.
+// The zero value is ready to use.
+type loadGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+// do runs fn once for all concurrent callers passing the same key,
+// handing each of them the same result.
+func (g *loadGroup[K, V]) do(key K, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCall[V])
+	}
+	if lc, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		lc.wg.Wait()
+		return lc.value, lc.err
+	}
+	lc := &loadCall[V]{err: errLoaderPanic}
+	lc.wg.Add(1)
+	g.calls[key] = lc
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		lc.wg.Done()
+	}()
+	lc.value, lc.err = fn()
+	return lc.value, lc.err
+}
+
+// GetOrLoad looks up a key's value from the cache, calling loader on a
+// miss and adding its value. Concurrent misses for the same key share
+// one loader call, and loader errors are returned without being cached.
+func (c *lru[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	return c.loads.do(key, func() (V, error) {
+		// a previous load may have finished since the miss
+		if value, ok := c.Peek(key); ok {
+			return value, nil
+		}
+		value, err := loader(key)
+		if err != nil {
+			return value, err
+		}
+		c.Add(key, value)
+		return value, nil
+	})
+}
+
+// GetOrLoad looks up a key's value from its shard, calling loader on a
+// miss and adding its value.
+func (c *sharded[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	return c.shard(key).GetOrLoad(key, loader)
+}
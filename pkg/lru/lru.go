@@ -2,16 +2,20 @@ package lru
 
 import (
 	"errors"
+	"io"
 	"sync"
+	"time"
 )
 
 const (
 	// defaultEvictedBufferSize defines the default buffer size to store evicted key/val
 	defaultEvictedBufferSize = 16
+	// minJanitorInterval bounds how often the janitor may wake up
+	minJanitorInterval = time.Millisecond
 )
 
-// LRU is the interface for simple LRU cache.
-type LRU[K comparable, V any] interface {
+// Cache is the interface shared by every cache policy in this package.
+type Cache[K comparable, V any] interface {
 	// Add a key/value, bool if evicted
 	Add(key K, value V) bool
 	// Get key/value, bool if found
@@ -22,11 +26,7 @@ type LRU[K comparable, V any] interface {
 	Peek(key K) (value V, ok bool)
 	// Removes a key from the cache.
 	Remove(key K) bool
-	// Removes the oldest entry from cache.
-	RemoveOldest() (K, V, bool)
-	// Returns the oldest entry from the cache. #key, value, isFound
-	GetOldest() (K, V, bool)
-	// Returns a slice of the keys in the cache, from oldest to newest.
+	// Returns a slice of the keys in the cache.
 	Keys() []K
 	// Returns the number of items in the cache.
 	Len() int
@@ -40,84 +40,299 @@ type LRU[K comparable, V any] interface {
 	PeekOrAdd(K, V) (V, bool, bool)
 }
 
+// LRU is the interface for simple LRU cache.
+type LRU[K comparable, V any] interface {
+	Cache[K, V]
+	// Add a key/value that expires after ttl, bool if evicted
+	AddWithTTL(key K, value V, ttl time.Duration) bool
+	// Get key/value, calling loader once for concurrent misses
+	GetOrLoad(key K, loader func(key K) (V, error)) (V, error)
+	// Removes the oldest entry from cache.
+	RemoveOldest() (K, V, bool)
+	// Returns the oldest entry from the cache. #key, value, isFound
+	GetOldest() (K, V, bool)
+	// Returns the total weight of the items in the cache.
+	Cost() int
+	// Pins a key so capacity eviction skips it, bool if found
+	Pin(key K) bool
+	// Unpins a key, evicting if the cache is over size, bool if found
+	Unpin(key K) bool
+	// Calls fn for every entry from oldest to newest until fn fails.
+	Each(fn func(key K, value V) error) error
+	// Calls fn for every entry from newest to oldest until fn fails.
+	EachReverse(fn func(key K, value V) error) error
+	// Returns a snapshot of the cache counters.
+	Stats() Stats
+	// Returns a channel of evictions and a func to unsubscribe.
+	Subscribe(size int, policy DeliveryPolicy) (<-chan Event[K, V], func())
+	// Writes the entries from oldest to newest.
+	Snapshot(io.Writer) error
+	// Adds the entries of a snapshot in their recency order.
+	Restore(io.Reader) error
+	// Stops the background janitor, if any.
+	Stop()
+}
+
+// EvictReason tells why an entry left the cache.
+type EvictReason int
+
+const (
+	unknownReason EvictReason = iota
+	// EvictCapacity is an entry pushed out by a newer one.
+	EvictCapacity
+	// EvictRemoved is an entry removed with Remove or RemoveOldest.
+	EvictRemoved
+	// EvictPurged is an entry cleared by Purge.
+	EvictPurged
+	// EvictResized is an entry dropped by a shrinking Resize.
+	EvictResized
+	// EvictExpired is an entry reaped after its time-to-live.
+	EvictExpired
+)
+
+// String returns the reason name.
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictRemoved:
+		return "removed"
+	case EvictPurged:
+		return "purged"
+	case EvictResized:
+		return "resized"
+	case EvictExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// Config holds the settings for NewWithConfig.
+type Config[K comparable, V any] struct {
+	// Size is the maximum number of entries, or the maximum total
+	// weight when Weigher is set.
+	Size int
+	// Weigher returns the weight of an entry, nil weighs every entry 1.
+	// An entry heavier than Size is evicted alone as EvictCapacity.
+	Weigher func(key K, value V) int
+	// TTL is the time-to-live given to entries by Add, zero never expires.
+	TTL time.Duration
+	// JanitorInterval is how often expired entries are reaped.
+	// Defaults to half of TTL, zero with no TTL disables the janitor.
+	JanitorInterval time.Duration
+	// OnEvicted is called outside of the lock for every entry leaving the cache.
+	OnEvicted func(key K, value V, reason EvictReason)
+	// OnShardEvicted is called like OnEvicted by a sharded cache, with
+	// the index of the shard the entry left.
+	OnShardEvicted func(shard int, key K, value V, reason EvictReason)
+	// Hash spreads keys across shards, nil hashes strings, numbers and
+	// booleans and fails for other keys.
+	Hash func(key K) uint64
+	// Codec writes and reads snapshots, nil uses GobCodec.
+	Codec Codec
+	// KeepSize makes Restore keep Size instead of taking the size of the
+	// snapshot, evicting the oldest entries that do not fit.
+	KeepSize bool
+}
+
 // Cache is a thread-safe fixed size LRU cache.
 type lru[K comparable, V any] struct {
-	lru         *cLRU[K, V]
-	evictedKeys []K
-	evictedVals []V
-	onEvictedCB func(k K, v V)
+	lru            *cLRU[K, V]
+	evictedKeys    []K
+	evictedVals    []V
+	evictedReasons []EvictReason
+	onEvictedCB    func(k K, v V, r EvictReason)
+	events         *eventHub[K, V]
+	loads          loadGroup[K, V]
+	stats          counters
+	codec          Codec
+	keepSize       bool
+	stop           chan struct{}
+	stopOnce       sync.Once
 	sync.RWMutex
 }
 
 // New constructs a fixed size cache with the given eviction
 // callback.
 func New[K comparable, V any](size int, onEvicted func(key K, value V)) (LRU[K, V], error) {
+	cfg := Config[K, V]{Size: size}
+	if onEvicted != nil {
+		cfg.OnEvicted = func(k K, v V, _ EvictReason) {
+			onEvicted(k, v)
+		}
+	}
+	return NewWithConfig(cfg)
+}
+
+// NewWithConfig constructs a fixed size cache from cfg, starting a
+// janitor to reap expired entries when a TTL or interval is set.
+func NewWithConfig[K comparable, V any](cfg Config[K, V]) (LRU[K, V], error) {
+	return newLRU(cfg, &eventHub[K, V]{})
+}
+
+// newLRU constructs a cache from cfg publishing its evictions to events.
+func newLRU[K comparable, V any](cfg Config[K, V], events *eventHub[K, V]) (*lru[K, V], error) {
 	// create a cache with default settings
 	c := &lru[K, V]{
-		onEvictedCB: onEvicted,
+		onEvictedCB: cfg.OnEvicted,
+		events:      events,
+		codec:       cfg.Codec,
+		keepSize:    cfg.KeepSize,
 	}
-	if onEvicted != nil {
-		c.initEvictBuffers()
-		onEvicted = c.onEvicted
+	if c.codec == nil {
+		c.codec = GobCodec
 	}
+	c.initEvictBuffers()
 	err := (error)(nil)
-	c.lru, err = NewcLRU(size, onEvicted)
-	return c, err
+	c.lru, err = NewcLRU[K, V](cfg.Size, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.lru.onEvictReason = c.onEvicted
+	c.lru.ttl = cfg.TTL
+	c.lru.weigher = cfg.Weigher
+	interval := cfg.JanitorInterval
+	if interval <= 0 {
+		interval = cfg.TTL / 2
+	}
+	if interval > 0 {
+		if interval < minJanitorInterval {
+			interval = minJanitorInterval
+		}
+		c.stop = make(chan struct{})
+		go c.janitor(interval)
+	}
+	return c, nil
+}
+
+// janitor periodically reaps expired entries until stopped.
+func (c *lru[K, V]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
+// removeExpired reaps every expired entry, returning the number reaped.
+func (c *lru[K, V]) removeExpired() (reaped int) {
+	c.Lock()
+	reaped = c.lru.removeExpired(time.Now())
+	ks, vs, rs := c.takeEvicted()
+	c.Unlock()
+	c.notify(ks, vs, rs)
+	return reaped
+}
+
+// Stop stops the janitor. Expired entries stay hidden from lookups but
+// are no longer reaped in the background.
+func (c *lru[K, V]) Stop() {
+	if c.stop == nil {
+		return
+	}
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
 }
 
 func (c *lru[K, V]) initEvictBuffers() {
 	c.evictedKeys = make([]K, 0, defaultEvictedBufferSize)
 	c.evictedVals = make([]V, 0, defaultEvictedBufferSize)
+	c.evictedReasons = make([]EvictReason, 0, defaultEvictedBufferSize)
 }
 
-// onEvicted save evicted key/val and sent in externally registered callback
-// outside of critical section
-func (c *lru[K, V]) onEvicted(k K, v V) {
+// onEvicted counts an evicted key/val and, if anyone listens, saves it to
+// be sent to the externally registered callback and subscribers outside
+// of critical section
+func (c *lru[K, V]) onEvicted(k K, v V, r EvictReason) {
+	c.stats.evict(r, 1)
+	if c.onEvictedCB == nil && !c.events.active() {
+		return
+	}
 	c.evictedKeys = append(c.evictedKeys, k)
 	c.evictedVals = append(c.evictedVals, v)
+	c.evictedReasons = append(c.evictedReasons, r)
+}
+
+// takeEvicted hands over the saved key/vals, it must be called with the
+// lock held.
+func (c *lru[K, V]) takeEvicted() (ks []K, vs []V, rs []EvictReason) {
+	if len(c.evictedKeys) == 0 {
+		return nil, nil, nil
+	}
+	ks, vs, rs = c.evictedKeys, c.evictedVals, c.evictedReasons
+	c.initEvictBuffers()
+	return ks, vs, rs
+}
+
+// notify sends key/vals from takeEvicted to the callback and subscribers,
+// it must be called without the lock held.
+func (c *lru[K, V]) notify(ks []K, vs []V, rs []EvictReason) {
+	if len(ks) == 0 {
+		return
+	}
+	if c.onEvictedCB != nil {
+		for i := 0; i < len(ks); i++ {
+			c.onEvictedCB(ks[i], vs[i], rs[i])
+		}
+	}
+	c.events.publish(ks, vs, rs)
+}
+
+// Subscribe returns a channel receiving every eviction, buffered by size
+// and handled by policy when full, and a func to unsubscribe that closes
+// the channel.
+func (c *lru[K, V]) Subscribe(size int, policy DeliveryPolicy) (<-chan Event[K, V], func()) {
+	return c.events.subscribe(size, policy)
 }
 
 // Purge is used to completely clear the cache.
 func (c *lru[K, V]) Purge() {
-	var ks []K
-	var vs []V
 	c.Lock()
 	c.lru.purge()
-	if c.onEvictedCB != nil && len(c.evictedKeys) > 0 {
-		ks, vs = c.evictedKeys, c.evictedVals
-		c.initEvictBuffers()
-	}
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
 	// invoke callback outside of critical section
-	if c.onEvictedCB != nil {
-		for i := 0; i < len(ks); i++ {
-			c.onEvictedCB(ks[i], vs[i])
-		}
-	}
+	c.notify(ks, vs, rs)
 }
 
-// Add adds a value to the cache. Returns true if an eviction occurred.
+// Add adds a value to the cache with the default time-to-live.
+// Returns true if an eviction occurred.
 func (c *lru[K, V]) Add(key K, value V) (evicted bool) {
-	var k K
-	var v V
+	return c.AddWithTTL(key, value, c.lru.ttl)
+}
+
+// AddWithTTL adds a value to the cache that expires after ttl, zero
+// never expires. Returns true if an eviction occurred.
+func (c *lru[K, V]) AddWithTTL(key K, value V, ttl time.Duration) (evicted bool) {
 	c.Lock()
-	evicted = c.lru.add(key, value)
-	if c.onEvictedCB != nil && evicted {
-		k, v = c.evictedKeys[0], c.evictedVals[0]
-		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
-	}
+	evicted = c.add(key, value, ttl)
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && evicted {
-		c.onEvictedCB(k, v)
-	}
+	c.notify(ks, vs, rs)
 	return
 }
 
+// add adds a value to the cache, counting it. It must be called with the
+// lock held.
+func (c *lru[K, V]) add(key K, value V, ttl time.Duration) (evicted bool) {
+	_, updated := c.lru.items[key]
+	c.stats.add(updated)
+	return c.lru.addWithTTL(key, value, ttl)
+}
+
 // Get looks up a key's value from the cache.
 func (c *lru[K, V]) Get(key K) (value V, ok bool) {
 	c.Lock()
 	value, ok = c.lru.get(key)
 	c.Unlock()
+	c.stats.lookup(ok)
 	return value, ok
 }
 
@@ -143,22 +358,15 @@ func (c *lru[K, V]) Peek(key K) (value V, ok bool) {
 // recent-ness or deleting it for being stale, and if not, adds the value.
 // Returns whether found and whether an eviction occurred.
 func (c *lru[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
-	var k K
-	var v V
 	c.Lock()
 	if c.lru.contains(key) {
 		c.Unlock()
 		return true, false
 	}
-	evicted = c.lru.add(key, value)
-	if c.onEvictedCB != nil && evicted {
-		k, v = c.evictedKeys[0], c.evictedVals[0]
-		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
-	}
+	evicted = c.add(key, value, c.lru.ttl)
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && evicted {
-		c.onEvictedCB(k, v)
-	}
+	c.notify(ks, vs, rs)
 	return false, evicted
 }
 
@@ -166,85 +374,51 @@ func (c *lru[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
 // recent-ness or deleting it for being stale, and if not, adds the value.
 // Returns whether found and whether an eviction occurred.
 func (c *lru[K, V]) PeekOrAdd(key K, value V) (previous V, ok, evicted bool) {
-	var k K
-	var v V
 	c.Lock()
 	previous, ok = c.lru.peek(key)
 	if ok {
 		c.Unlock()
 		return previous, true, false
 	}
-	evicted = c.lru.add(key, value)
-	if c.onEvictedCB != nil && evicted {
-		k, v = c.evictedKeys[0], c.evictedVals[0]
-		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
-	}
+	evicted = c.add(key, value, c.lru.ttl)
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && evicted {
-		c.onEvictedCB(k, v)
-	}
+	c.notify(ks, vs, rs)
 	return
 }
 
 // Remove removes the provided key from the cache.
 func (c *lru[K, V]) Remove(key K) (present bool) {
-	var k K
-	var v V
 	c.Lock()
 	present = c.lru.remove(key)
-	if c.onEvictedCB != nil && present {
-		k, v = c.evictedKeys[0], c.evictedVals[0]
-		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
-	}
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && present {
-		c.onEvictedCB(k, v)
-	}
+	c.notify(ks, vs, rs)
 	return
 }
 
 // Resize changes the cache size.
 func (c *lru[K, V]) Resize(size int) (evicted int) {
-	var ks []K
-	var vs []V
 	c.Lock()
 	evicted = c.lru.resize(size)
-	if c.onEvictedCB != nil && evicted > 0 {
-		ks, vs = c.evictedKeys, c.evictedVals
-		c.initEvictBuffers()
-	}
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && evicted > 0 {
-		for i := 0; i < len(ks); i++ {
-			c.onEvictedCB(ks[i], vs[i])
-		}
-	}
+	c.notify(ks, vs, rs)
 	return evicted
 }
 
-// RemoveOldest removes the oldest item from the cache.
+// RemoveOldest removes the oldest unexpired item from the cache, reaping
+// the expired ones older than it.
 func (c *lru[K, V]) RemoveOldest() (key K, value V, ok bool) {
-	var k K
-	var v V
 	c.Lock()
-	// key, value, ok = c.lru.removeOldest()
-	ent := c.lru.evictList.back()
-	if ent != nil {
-		c.lru.removeElement(ent)
-		key, value, ok = ent.key, ent.value, true
-	}
-	if c.onEvictedCB != nil && ok {
-		k, v = c.evictedKeys[0], c.evictedVals[0]
-		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
-	}
+	key, value, ok = c.lru.removeOldest()
+	ks, vs, rs := c.takeEvicted()
 	c.Unlock()
-	if c.onEvictedCB != nil && ok {
-		c.onEvictedCB(k, v)
-	}
+	c.notify(ks, vs, rs)
 	return
 }
 
-// GetOldest returns the oldest entry
+// GetOldest returns the oldest unexpired entry
 func (c *lru[K, V]) GetOldest() (key K, value V, ok bool) {
 	c.RLock()
 	key, value, ok = c.lru.getOldest()
@@ -252,7 +426,51 @@ func (c *lru[K, V]) GetOldest() (key K, value V, ok bool) {
 	return
 }
 
+// Pin keeps a key in the cache when it is full, only Remove, Purge,
+// RemoveOldest and expiry take it out. Pinned entries still count towards
+// the size, so the unpinned ones are evicted to make room.
+// Returns false if the key is not in the cache.
+func (c *lru[K, V]) Pin(key K) (present bool) {
+	c.Lock()
+	present = c.lru.pin(key, true)
+	c.Unlock()
+	return present
+}
+
+// Unpin lets a pinned key be evicted again, evicting the oldest entries
+// if the cache is over its size. Returns false if the key is not in the
+// cache.
+func (c *lru[K, V]) Unpin(key K) (present bool) {
+	c.Lock()
+	present = c.lru.pin(key, false)
+	if present {
+		c.lru.evictOverweight(c.lru.size, EvictCapacity)
+	}
+	ks, vs, rs := c.takeEvicted()
+	c.Unlock()
+	c.notify(ks, vs, rs)
+	return present
+}
+
+// Each calls fn for every entry from oldest to newest, without changing
+// their recency, and stops at the first error which it returns. It holds
+// a read lock, so fn must not modify the cache.
+func (c *lru[K, V]) Each(fn func(key K, value V) error) error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lru.each(false, fn)
+}
+
+// EachReverse is Each from newest to oldest.
+func (c *lru[K, V]) EachReverse(fn func(key K, value V) error) error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lru.each(true, fn)
+}
+
 // Keys returns a slice of the keys in the cache, from oldest to newest.
+// Expired keys are counted until they are reaped, by the janitor or when
+// they would be evicted.
 func (c *lru[K, V]) Keys() []K {
 	c.RLock()
 	keys := c.lru.keys()
@@ -260,7 +478,8 @@ func (c *lru[K, V]) Keys() []K {
 	return keys
 }
 
-// Len returns the number of items in the cache.
+// Len returns the number of items in the cache, counting expired items
+// until they are reaped like Keys.
 func (c *lru[K, V]) Len() int {
 	c.RLock()
 	length := c.lru.len()
@@ -268,6 +487,24 @@ func (c *lru[K, V]) Len() int {
 	return length
 }
 
+// Cost returns the total weight of the items in the cache.
+func (c *lru[K, V]) Cost() int {
+	c.RLock()
+	cost := c.lru.cost
+	c.RUnlock()
+	return cost
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *lru[K, V]) Stats() Stats {
+	c.RLock()
+	stats := c.stats.snapshot()
+	stats.Len = c.lru.len()
+	stats.Cost = c.lru.cost
+	c.RUnlock()
+	return stats
+}
+
 // CORE
 ////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////
@@ -279,9 +516,14 @@ type EvictCallback[K comparable, V any] func(key K, value V)
 // cLRU implements a non-thread safe fixed size LRU cache
 type cLRU[K comparable, V any] struct {
 	size      int
+	cost      int
+	ttl       time.Duration
+	weigher   func(K, V) int
 	evictList *lruList[K, V]
 	items     map[K]*entry[K, V]
 	onEvict   EvictCallback[K, V]
+	// onEvictReason, when set, is called instead of onEvict
+	onEvictReason func(K, V, EvictReason)
 }
 
 // NewcLRU constructs an LRU of the given size
@@ -302,41 +544,127 @@ func NewcLRU[K comparable, V any](size int, onEvict EvictCallback[K, V]) (*cLRU[
 // Purge is used to completely clear the cache.
 func (c *cLRU[K, V]) purge() {
 	for k, v := range c.items {
-		if c.onEvict != nil {
-			c.onEvict(k, v.value)
-		}
+		c.evicted(k, v.value, EvictPurged)
 		delete(c.items, k)
 	}
 	c.evictList.init()
+	c.cost = 0
 }
 
 // Add adds a value to the cache.  Returns true if an eviction occurred.
 func (c *cLRU[K, V]) add(key K, value V) (evicted bool) {
+	return c.addWithTTL(key, value, c.ttl)
+}
+
+// addWithTTL adds a value to the cache that expires after ttl.
+// Returns true if an eviction occurred.
+func (c *cLRU[K, V]) addWithTTL(key K, value V, ttl time.Duration) (evicted bool) {
+	now := time.Now()
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+	cost := c.weigh(key, value)
+	// An entry heavier than the cache is dropped alone, the entries it
+	// would flush are kept
+	if cost > c.size {
+		c.detach(key)
+		c.evicted(key, value, EvictCapacity)
+		return true
+	}
 	// Check for existing item
 	if ent, ok := c.items[key]; ok {
 		c.evictList.moveToFront(ent)
 		ent.value = value
-		return false
+		ent.expiresAt = expiresAt
+		ent.used = now
+		c.cost += cost - ent.cost
+		ent.cost = cost
+	} else {
+		// Add new item
+		ent := c.evictList.pushFront(key, value)
+		ent.expiresAt = expiresAt
+		ent.used = now
+		ent.cost = cost
+		c.items[key] = ent
+		c.cost += cost
 	}
 
-	// Add new item
-	ent := c.evictList.pushFront(key, value)
-	c.items[key] = ent
-
-	evict := c.evictList.length() > c.size
 	// Verify size not exceeded
-	if evict {
-		if ent := c.evictList.back(); ent != nil {
-			c.removeElement(ent)
+	return c.evictOverweight(c.size, EvictCapacity) > 0
+}
+
+// weigh returns the weight of an entry, 1 without a weigher.
+func (c *cLRU[K, V]) weigh(key K, value V) int {
+	if c.weigher == nil {
+		return 1
+	}
+	if w := c.weigher(key, value); w > 0 {
+		return w
+	}
+	return 0
+}
+
+// evictOverweight removes the oldest unpinned entries until the total
+// weight fits in size, returning the number removed. Entries that had
+// already expired are reported as such instead of with reason.
+func (c *cLRU[K, V]) evictOverweight(size int, reason EvictReason) (evicted int) {
+	now := time.Now()
+	for ent := c.evictList.back(); ent != nil && c.cost > size; {
+		prev := ent.prevEntry()
+		switch {
+		case ent.pinned:
+		case ent.expired(now):
+			c.evictElement(ent, EvictExpired)
+			evicted++
+		default:
+			c.evictElement(ent, reason)
+			evicted++
 		}
+		ent = prev
 	}
-	return evict
+	return evicted
+}
+
+// pin sets whether capacity eviction skips a key, returning if the key
+// was contained.
+func (c *cLRU[K, V]) pin(key K, pinned bool) (present bool) {
+	if ent, ok := c.items[key]; ok {
+		ent.pinned = pinned
+		return true
+	}
+	return false
+}
+
+// each calls fn for every unexpired entry from oldest to newest, or
+// newest to oldest if reverse, until fn returns an error.
+func (c *cLRU[K, V]) each(reverse bool, fn func(K, V) error) error {
+	now := time.Now()
+	ent := c.evictList.back()
+	if reverse {
+		ent = c.evictList.front()
+	}
+	for ent != nil {
+		if !ent.expired(now) {
+			if err := fn(ent.key, ent.value); err != nil {
+				return err
+			}
+		}
+		if reverse {
+			ent = ent.nextEntry()
+		} else {
+			ent = ent.prevEntry()
+		}
+	}
+	return nil
 }
 
 // Get looks up a key's value from the cache.
 func (c *cLRU[K, V]) get(key K) (value V, ok bool) {
-	if ent, ok := c.items[key]; ok {
+	now := time.Now()
+	if ent, ok := c.items[key]; ok && !ent.expired(now) {
 		c.evictList.moveToFront(ent)
+		ent.used = now
 		return ent.value, true
 	}
 	return
@@ -345,15 +673,14 @@ func (c *cLRU[K, V]) get(key K) (value V, ok bool) {
 // Contains checks if a key is in the cache, without updating the recent-ness
 // or deleting it for being stale.
 func (c *cLRU[K, V]) contains(key K) (ok bool) {
-	_, ok = c.items[key]
-	return ok
+	ent, ok := c.items[key]
+	return ok && !ent.expired(time.Now())
 }
 
 // Peek returns the key value (or undefined if not found) without updating
 // the "recently used"-ness of the key.
 func (c *cLRU[K, V]) peek(key K) (value V, ok bool) {
-	var ent *entry[K, V]
-	if ent, ok = c.items[key]; ok {
+	if ent, ok := c.items[key]; ok && !ent.expired(time.Now()) {
 		return ent.value, true
 	}
 	return
@@ -369,16 +696,70 @@ func (c *cLRU[K, V]) remove(key K) (present bool) {
 	return false
 }
 
+// removeExpired removes every entry that expired by now, returning the
+// number removed.
+func (c *cLRU[K, V]) removeExpired(now time.Time) (removed int) {
+	for ent := c.evictList.back(); ent != nil; {
+		prev := ent.prevEntry()
+		if ent.expired(now) {
+			c.evictElement(ent, EvictExpired)
+			removed++
+		}
+		ent = prev
+	}
+	return removed
+}
 
-// GetOldest returns the oldest entry
-func (c *cLRU[K, V]) getOldest() (key K, value V, ok bool) {
-	if ent := c.evictList.back(); ent != nil {
+// removeOldest removes the oldest unexpired entry, returning it. Expired
+// entries before it are reaped.
+func (c *cLRU[K, V]) removeOldest() (key K, value V, ok bool) {
+	now := time.Now()
+	for ent := c.evictList.back(); ent != nil; ent = c.evictList.back() {
+		if ent.expired(now) {
+			c.evictElement(ent, EvictExpired)
+			continue
+		}
+		c.removeElement(ent)
 		return ent.key, ent.value, true
 	}
 	return
 }
 
-// Keys returns a slice of the keys in the cache, from oldest to newest.
+// detach removes the provided key without calling onEvict, so the
+// entry can move to another list.
+func (c *cLRU[K, V]) detach(key K) (value V, ok bool) {
+	if ent, ok := c.items[key]; ok {
+		c.evictList.remove(ent)
+		delete(c.items, key)
+		c.cost -= ent.cost
+		return ent.value, true
+	}
+	return
+}
+
+// detachOldest removes the oldest entry without calling onEvict, so the
+// entry can move to another list.
+func (c *cLRU[K, V]) detachOldest() (key K, value V, ok bool) {
+	if ent := c.evictList.back(); ent != nil {
+		value, ok = c.detach(ent.key)
+		return ent.key, value, ok
+	}
+	return
+}
+
+// GetOldest returns the oldest unexpired entry
+func (c *cLRU[K, V]) getOldest() (key K, value V, ok bool) {
+	now := time.Now()
+	for ent := c.evictList.back(); ent != nil; ent = ent.prevEntry() {
+		if !ent.expired(now) {
+			return ent.key, ent.value, true
+		}
+	}
+	return
+}
+
+// Keys returns a slice of the keys in the cache, from oldest to newest,
+// with the expired ones not reaped yet.
 func (c *cLRU[K, V]) keys() []K {
 	keys := make([]K, c.evictList.length())
 	i := 0
@@ -389,24 +770,17 @@ func (c *cLRU[K, V]) keys() []K {
 	return keys
 }
 
-// Len returns the number of items in the cache.
+// Len returns the number of items in the cache, counting the expired ones
+// not reaped yet.
 func (c *cLRU[K, V]) len() int {
 	return c.evictList.length()
 }
 
-// Resize changes the cache size.
+// Resize changes the cache size, or weight budget with a weigher.
 func (c *cLRU[K, V]) resize(size int) (evicted int) {
-	diff := c.len() - size
-	if diff < 0 {
-		diff = 0
-	}
-	for i := 0; i < diff; i++ {
-		if ent := c.evictList.back(); ent != nil {
-			c.removeElement(ent)
-		}
-	}
+	evicted = c.evictOverweight(size, EvictResized)
 	c.size = size
-	return diff
+	return evicted
 }
 
 // // rremoveOldest removes the oldest item from the cache.
@@ -425,13 +799,25 @@ func (c *cLRU[K, V]) resize(size int) (evicted int) {
 // 	return
 // }
 
-
 // removeElement is used to remove a given list element from the cache
 func (c *cLRU[K, V]) removeElement(e *entry[K, V]) {
+	c.evictElement(e, EvictRemoved)
+}
+
+// evictElement removes a given list element from the cache for reason
+func (c *cLRU[K, V]) evictElement(e *entry[K, V], reason EvictReason) {
 	c.evictList.remove(e)
 	delete(c.items, e.key)
-	if c.onEvict != nil {
-		c.onEvict(e.key, e.value)
+	c.cost -= e.cost
+	c.evicted(e.key, e.value, reason)
+}
+
+// evicted calls the eviction callback, if any
+func (c *cLRU[K, V]) evicted(k K, v V, reason EvictReason) {
+	if c.onEvictReason != nil {
+		c.onEvictReason(k, v, reason)
+	} else if c.onEvict != nil {
+		c.onEvict(k, v)
 	}
 }
 
@@ -452,6 +838,24 @@ type entry[K comparable, V any] struct {
 
 	// The value stored with this element.
 	value V
+
+	// The time this element expires, zero if it never does.
+	expiresAt time.Time
+
+	// The time this element was last added or read, which orders the
+	// elements of different shards.
+	used time.Time
+
+	// The weight this element counts against the cache size.
+	cost int
+
+	// Whether capacity eviction skips this element.
+	pinned bool
+}
+
+// expired reports whether the element has expired by now.
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
 }
 
 // prevEntry returns the previous list element or nil.
@@ -462,6 +866,14 @@ func (e *entry[K, V]) prevEntry() *entry[K, V] {
 	return nil
 }
 
+// nextEntry returns the next list element or nil.
+func (e *entry[K, V]) nextEntry() *entry[K, V] {
+	if n := e.next; e.list != nil && n != &e.list.root {
+		return n
+	}
+	return nil
+}
+
 // lruList represents a doubly linked list.
 // The zero value for lruList is an empty list ready to use.
 type lruList[K comparable, V any] struct {
@@ -492,6 +904,14 @@ func (l *lruList[K, V]) back() *entry[K, V] {
 	return l.root.prev
 }
 
+// front returns the first element of list l or nil if the list is empty.
+func (l *lruList[K, V]) front() *entry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
 // lazyInit lazily initializes a zero List value.
 func (l *lruList[K, V]) lazyInit() {
 	if l.root.next == nil {
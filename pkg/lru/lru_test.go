@@ -1,10 +1,18 @@
 package lru
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math"
 	"math/big"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // CORE
@@ -281,6 +289,163 @@ func BenchmarkLRU_Freq(b *testing.B) {
 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
 }
 
+func BenchmarkShardedLRU_Rand(b *testing.B) {
+	l, err := NewSharded[int64, int64](16, 8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		trace[i] = getRand(b) % 32768
+	}
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			if _, ok := l.Get(trace[i]); ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func BenchmarkShardedLRU_Freq(b *testing.B) {
+	l, err := NewSharded[int64, int64](16, 8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		if i%2 == 0 {
+			trace[i] = getRand(b) % 16384
+		} else {
+			trace[i] = getRand(b) % 32768
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Add(trace[i], trace[i])
+	}
+	var hit, miss int
+	for i := 0; i < b.N; i++ {
+		if _, ok := l.Get(trace[i]); ok {
+			hit++
+		} else {
+			miss++
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func BenchmarkTinyLFU_Rand(b *testing.B) {
+	l, err := NewTinyLFU[int64, int64](8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		trace[i] = getRand(b) % 32768
+	}
+
+	b.ResetTimer()
+
+	var hit, miss int
+	for i := 0; i < 2*b.N; i++ {
+		if i%2 == 0 {
+			l.Add(trace[i], trace[i])
+		} else {
+			if _, ok := l.Get(trace[i]); ok {
+				hit++
+			} else {
+				miss++
+			}
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func BenchmarkTinyLFU_Freq(b *testing.B) {
+	l, err := NewTinyLFU[int64, int64](8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	trace := make([]int64, b.N*2)
+	for i := 0; i < b.N*2; i++ {
+		if i%2 == 0 {
+			trace[i] = getRand(b) % 16384
+		} else {
+			trace[i] = getRand(b) % 32768
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Add(trace[i], trace[i])
+	}
+	var hit, miss int
+	for i := 0; i < b.N; i++ {
+		if _, ok := l.Get(trace[i]); ok {
+			hit++
+		} else {
+			miss++
+		}
+	}
+	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+}
+
+func BenchmarkLRU_Parallel(b *testing.B) {
+	l, err := New[int64, int64](8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	benchmarkParallel(b, l)
+}
+
+func BenchmarkShardedLRU_Parallel(b *testing.B) {
+	l, err := NewSharded[int64, int64](16, 8192, nil)
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	benchmarkParallel(b, l)
+}
+
+// benchmarkParallel runs a mixed Add/Get load from every GOMAXPROCS
+func benchmarkParallel(b *testing.B, l LRU[int64, int64]) {
+	trace := make([]int64, 1<<16)
+	for i := range trace {
+		trace[i] = getRand(b) % 32768
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(getRand(b) % int64(len(trace)))
+		for pb.Next() {
+			k := trace[i%len(trace)]
+			if i%2 == 0 {
+				l.Add(k, k)
+			} else {
+				l.Get(k)
+			}
+			i++
+		}
+	})
+}
+
 func TestLRU(t *testing.T) {
 	evictCounter := 0
 	onEvicted := func(k int, v int) {
@@ -542,6 +707,818 @@ func TestLRU_GetOldest_RemoveOldest(t *testing.T) {
 	}
 }
 
+// test that expired entries are missing and reaped with a reason
+func TestLRU_TTL(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[int]EvictReason{}
+	l, err := NewWithConfig(Config[int, int]{
+		Size:            8,
+		TTL:             20 * time.Millisecond,
+		JanitorInterval: 5 * time.Millisecond,
+		OnEvicted: func(k, v int, r EvictReason) {
+			mu.Lock()
+			reasons[k] = r
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Stop()
+
+	l.Add(1, 1)
+	l.AddWithTTL(2, 2, 0)
+	l.AddWithTTL(3, 3, time.Hour)
+	if v, ok := l.Get(1); !ok || v != 1 {
+		t.Errorf("1 should be set to 1: %v, %v", v, ok)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := l.Get(1); ok {
+		t.Errorf("1 should have expired")
+	}
+	if _, ok := l.Peek(1); ok {
+		t.Errorf("1 should have expired")
+	}
+	if l.Contains(1) {
+		t.Errorf("1 should have expired")
+	}
+	if !l.Contains(2) || !l.Contains(3) {
+		t.Errorf("2 and 3 should not have expired")
+	}
+	if l.Len() != 2 {
+		t.Errorf("bad len: %v", l.Len())
+	}
+	mu.Lock()
+	if r, ok := reasons[1]; !ok || r != EvictExpired {
+		t.Errorf("1 should have been reaped as expired: %v, %v", r, ok)
+	}
+	mu.Unlock()
+
+	l.Remove(2)
+	mu.Lock()
+	if r := reasons[2]; r != EvictRemoved {
+		t.Errorf("2 should have been removed: %v", r)
+	}
+	mu.Unlock()
+}
+
+// test that expired entries are hidden without a janitor
+func TestLRU_TTLStopped(t *testing.T) {
+	l, err := NewWithConfig(Config[int, int]{Size: 2, TTL: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Stop()
+	l.Stop()
+
+	l.Add(1, 1)
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.Get(1); ok {
+		t.Errorf("1 should have expired")
+	}
+	if l.Len() != 1 {
+		t.Errorf("1 should not have been reaped: %v", l.Len())
+	}
+	if contains, _ := l.ContainsOrAdd(1, 2); contains {
+		t.Errorf("1 should not have been contained")
+	}
+	if v, ok := l.Peek(1); !ok || v != 2 {
+		t.Errorf("1 should be set to 2: %v, %v", v, ok)
+	}
+}
+
+// test that the oldest entry is the oldest unexpired one
+func TestLRU_TTLOldest(t *testing.T) {
+	reasons := map[int]EvictReason{}
+	l, err := NewWithConfig(Config[int, int]{
+		Size: 4,
+		OnEvicted: func(k, v int, r EvictReason) {
+			reasons[k] = r
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Stop()
+
+	l.AddWithTTL(1, 1, time.Millisecond)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	time.Sleep(5 * time.Millisecond)
+	if k, v, ok := l.GetOldest(); !ok || k != 2 || v != 2 {
+		t.Fatalf("bad oldest: %v, %v, %v", k, v, ok)
+	}
+	if k, _, ok := l.RemoveOldest(); !ok || k != 2 {
+		t.Fatalf("bad oldest: %v, %v", k, ok)
+	}
+	if reasons[1] != EvictExpired || reasons[2] != EvictRemoved || l.Len() != 1 {
+		t.Fatalf("bad evictions: %v, len %v", reasons, l.Len())
+	}
+	l.AddWithTTL(4, 4, time.Millisecond)
+	l.Remove(3)
+	time.Sleep(5 * time.Millisecond)
+	if _, _, ok := l.GetOldest(); ok {
+		t.Fatalf("expired entries should not be the oldest")
+	}
+	if _, _, ok := l.RemoveOldest(); ok || l.Len() != 0 {
+		t.Fatalf("expired entries should be reaped: len %v", l.Len())
+	}
+}
+
+// test that a weigher bounds the total weight instead of the entry count
+func TestLRU_Weigher(t *testing.T) {
+	evicted := []string{}
+	l, err := NewWithConfig(Config[string, []byte]{
+		Size: 10,
+		Weigher: func(k string, v []byte) int {
+			return len(v)
+		},
+		OnEvicted: func(k string, v []byte, r EvictReason) {
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("a", make([]byte, 4))
+	l.Add("b", make([]byte, 4))
+	if l.Cost() != 8 || l.Len() != 2 {
+		t.Fatalf("bad cost: %v, len %v", l.Cost(), l.Len())
+	}
+	if !l.Add("c", make([]byte, 6)) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Cost() != 10 || len(evicted) != 1 || evicted[0] != "a" {
+		t.Fatalf("bad cost: %v, evicted %v", l.Cost(), evicted)
+	}
+
+	// growing an existing entry evicts the others
+	if !l.Add("b", make([]byte, 8)) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Cost() != 8 || l.Contains("c") || !l.Contains("b") {
+		t.Fatalf("bad cost: %v, keys %v", l.Cost(), l.Keys())
+	}
+
+	// an entry heavier than the budget is dropped alone
+	if !l.Add("d", make([]byte, 11)) {
+		t.Errorf("should have an eviction")
+	}
+	if l.Cost() != 8 || l.Len() != 1 || l.Contains("d") || evicted[len(evicted)-1] != "d" {
+		t.Fatalf("bad cost: %v, keys %v, evicted %v", l.Cost(), l.Keys(), evicted)
+	}
+	// and so is an entry grown over it
+	l.Add("b", make([]byte, 11))
+	if l.Cost() != 0 || l.Len() != 0 || evicted[len(evicted)-1] != "b" {
+		t.Fatalf("bad cost: %v, keys %v, evicted %v", l.Cost(), l.Keys(), evicted)
+	}
+
+	l.Add("e", make([]byte, 3))
+	l.Add("f", make([]byte, 3))
+	l.Add("g", make([]byte, 3))
+	if evicted := l.Resize(6); evicted != 1 || l.Cost() != 6 || l.Contains("e") {
+		t.Fatalf("bad resize: %v, cost %v", evicted, l.Cost())
+	}
+	l.Remove("f")
+	if l.Cost() != 3 {
+		t.Fatalf("bad cost: %v", l.Cost())
+	}
+	l.Purge()
+	if l.Cost() != 0 {
+		t.Fatalf("bad cost: %v", l.Cost())
+	}
+}
+
+// test that concurrent misses share one loader call and errors are not cached
+func TestLRU_GetOrLoad(t *testing.T) {
+	l, err := New[int, int](8, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(k int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return k * 2, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := l.GetOrLoad(1, loader); err != nil || v != 2 {
+				t.Errorf("bad load: %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader should have been called once: %v", calls)
+	}
+	if v, ok := l.Peek(1); !ok || v != 2 {
+		t.Errorf("1 should be set to 2: %v, %v", v, ok)
+	}
+
+	errLoad := errors.New("load failed")
+	failing := func(k int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errLoad
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := l.GetOrLoad(2, failing); err != errLoad {
+			t.Errorf("bad err: %v", err)
+		}
+	}
+	if calls != 3 || l.Contains(2) {
+		t.Errorf("errors should not be cached: %v", calls)
+	}
+	if v, err := l.GetOrLoad(1, failing); err != nil || v != 2 {
+		t.Errorf("hit should not call the loader: %v, %v", v, err)
+	}
+}
+
+// test that every operation is counted
+func TestLRU_Stats(t *testing.T) {
+	l, err := New[int, int](2, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Get(2)
+	l.Get(1)
+	l.Get(3)
+	l.Peek(1)
+	l.Remove(3)
+	l.Add(4, 4)
+	l.Resize(1)
+	l.RemoveOldest()
+	l.Add(5, 5)
+	l.Purge()
+
+	want := Stats{
+		Hits:            2,
+		Misses:          1,
+		Adds:            5,
+		Updates:         1,
+		EvictedCapacity: 1,
+		EvictedRemoved:  2,
+		EvictedPurged:   1,
+		EvictedResized:  1,
+	}
+	if got := l.Stats(); got != want {
+		t.Fatalf("bad stats: %+v", got)
+	}
+	if r := want.HitRatio(); r < 0.66 || r > 0.67 {
+		t.Errorf("bad hit ratio: %v", r)
+	}
+	if n := want.Evictions(); n != 5 {
+		t.Errorf("bad evictions: %v", n)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	l, err := NewSharded[int, int](2, 4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add(1, 1)
+	l.Get(1)
+	l.Get(2)
+
+	w := httptest.NewRecorder()
+	StatsHandler(map[string]StatsReporter{"se\"ss": l}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE lru_hits_total counter",
+		`lru_hits_total{cache="se\"ss"} 1`,
+		`lru_misses_total{cache="se\"ss"} 1`,
+		`lru_adds_total{cache="se\"ss"} 1`,
+		`lru_evictions_total{cache="se\"ss",reason="capacity"} 0`,
+		`lru_hit_ratio{cache="se\"ss"} 0.5`,
+		`lru_entries{cache="se\"ss"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+// test that a snapshot restores the recency order with either codec
+func TestLRU_SnapshotRestore(t *testing.T) {
+	for _, codec := range []Codec{nil, GobCodec, JSONCodec} {
+		l, err := NewWithConfig(Config[string, int]{Size: 4, Codec: codec})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		l.Add("a", 1)
+		l.Add("b", 2)
+		l.AddWithTTL("c", 3, time.Hour)
+		l.AddWithTTL("d", 4, time.Nanosecond)
+		l.Get("a")
+
+		buf := &bytes.Buffer{}
+		if err := l.Snapshot(buf); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		snapshot := buf.Bytes()
+
+		// same size, same order, expired entries dropped
+		r, _ := NewWithConfig(Config[string, int]{Size: 4, Codec: codec})
+		if err := r.Restore(bytes.NewReader(snapshot)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if keys := r.Keys(); fmt.Sprint(keys) != "[b c a]" {
+			t.Fatalf("bad keys: %v", keys)
+		}
+		if v, ok := r.Peek("c"); !ok || v != 3 {
+			t.Fatalf("c should be set to 3: %v, %v", v, ok)
+		}
+
+		// the size comes back with the entries
+		r, _ = NewWithConfig(Config[string, int]{Size: 2, Codec: codec})
+		if err := r.Restore(bytes.NewReader(snapshot)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if keys := r.Keys(); fmt.Sprint(keys) != "[b c a]" {
+			t.Fatalf("bad keys: %v", keys)
+		}
+		for i := 0; i < 4; i++ {
+			r.Add(fmt.Sprint(i), i)
+		}
+		if r.Len() != 4 {
+			t.Fatalf("bad len: %v", r.Len())
+		}
+
+		// a kept smaller size evicts the oldest
+		evicted := []string{}
+		r, _ = NewWithConfig(Config[string, int]{
+			Size:     2,
+			Codec:    codec,
+			KeepSize: true,
+			OnEvicted: func(k string, v int, reason EvictReason) {
+				evicted = append(evicted, k)
+			},
+		})
+		if err := r.Restore(bytes.NewReader(snapshot)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if keys := r.Keys(); fmt.Sprint(keys) != "[c a]" || fmt.Sprint(evicted) != "[b]" {
+			t.Fatalf("bad keys: %v, evicted %v", keys, evicted)
+		}
+
+		if err := r.Restore(bytes.NewReader(snapshot[:len(snapshot)/2])); err == nil {
+			t.Fatalf("should fail on a truncated snapshot")
+		}
+	}
+}
+
+func TestShardedLRU_SnapshotRestore(t *testing.T) {
+	l, _ := NewSharded[int, int](4, 64, nil)
+	for i := 0; i < 64; i++ {
+		l.Add(i, i)
+	}
+	buf := &bytes.Buffer{}
+	if err := l.Snapshot(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r, _ := NewSharded[int, int](4, 32, nil)
+	if err := r.Restore(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fmt.Sprint(r.Keys()) != fmt.Sprint(l.Keys()) {
+		t.Fatalf("bad keys: %v", r.Keys())
+	}
+}
+
+// test that a sharded snapshot keeps one order across shards, which a
+// cache with other shards restores
+func TestShardedLRU_SnapshotOrder(t *testing.T) {
+	l, _ := NewShardedWithConfig(4, Config[int, int]{
+		Size: 100,
+		Hash: func(k int) uint64 { return uint64(k) },
+	})
+	for i := 0; i < 100; i++ {
+		l.Add(i, i)
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 10; i++ {
+		l.Get(i)
+	}
+	buf := &bytes.Buffer{}
+	if err := l.Snapshot(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snapshot := buf.Bytes()
+	_, entries, err := readSnapshot[int, int](bytes.NewReader(snapshot), GobCodec)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	order := map[int]int{}
+	for i, e := range entries {
+		order[e.Key] = i
+	}
+	for i := 0; i < 10; i++ {
+		if order[i] < 90 {
+			t.Fatalf("%v was read last, is at %v", i, order[i])
+		}
+	}
+
+	r, _ := NewShardedWithConfig(3, Config[int, int]{
+		Size: 100,
+		Hash: func(k int) uint64 { return uint64(k * 7) },
+	})
+	if err := r.Restore(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, s := range r.(*sharded[int, int]).shards {
+		last := -1
+		for _, k := range s.Keys() {
+			if order[k] < last {
+				t.Fatalf("%v restored out of order", k)
+			}
+			last = order[k]
+		}
+	}
+	if r.Len() != 100 {
+		t.Fatalf("bad len: %v", r.Len())
+	}
+}
+
+// test that the callback and subscribers get the eviction reason
+func TestLRU_EvictReasons(t *testing.T) {
+	reasons := []EvictReason{}
+	l, err := NewWithConfig(Config[int, int]{
+		Size: 2,
+		OnEvicted: func(k, v int, r EvictReason) {
+			reasons = append(reasons, r)
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	events, unsubscribe := l.Subscribe(16, DropWhenFull)
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.AddWithTTL(4, 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	l.Add(5, 5)
+	l.Remove(5)
+	l.Add(6, 6)
+	l.Add(7, 7)
+	l.Resize(1)
+	l.Purge()
+
+	want := []EvictReason{EvictCapacity, EvictCapacity, EvictCapacity, EvictRemoved, EvictExpired, EvictResized, EvictPurged}
+	if fmt.Sprint(reasons) != fmt.Sprint(want) {
+		t.Fatalf("bad reasons: %v", reasons)
+	}
+	unsubscribe()
+	unsubscribe()
+	got := []EvictReason{}
+	for e := range events {
+		if e.Key != e.Value {
+			t.Fatalf("Evict values not equal (%v!=%v)", e.Key, e.Value)
+		}
+		got = append(got, e.Reason)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("bad events: %v", got)
+	}
+	l.Add(8, 8)
+	l.Purge()
+	if len(reasons) != len(want)+1 {
+		t.Fatalf("callback should still be called: %v", reasons)
+	}
+}
+
+// test the delivery policies of a full subscriber
+func TestLRU_SubscribePolicy(t *testing.T) {
+	l, err := New[int, int](1, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dropped, unsubscribeDropped := l.Subscribe(1, DropWhenFull)
+	blocked, unsubscribeBlocked := l.Subscribe(1, BlockWhenFull)
+
+	l.Add(1, 1)
+	l.Add(2, 2) // fills both channels
+	done := make(chan struct{})
+	go func() {
+		l.Add(3, 3)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Add should block on a full BlockWhenFull subscriber")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if e := <-blocked; e.Key != 1 || e.Reason != EvictCapacity {
+		t.Fatalf("bad event: %+v", e)
+	}
+	<-done
+	if e := <-blocked; e.Key != 2 {
+		t.Fatalf("bad event: %+v", e)
+	}
+
+	// unsubscribing releases a blocked Add
+	l.Add(4, 4)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		unsubscribeBlocked()
+	}()
+	l.Add(5, 5)
+
+	unsubscribeDropped()
+	n := 0
+	for e := range dropped {
+		if e.Key != 1 {
+			t.Fatalf("bad event: %+v", e)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("events should have been dropped: %v", n)
+	}
+}
+
+// test that pinned keys survive capacity eviction and resizing
+func TestLRU_Pin(t *testing.T) {
+	evicted := []int{}
+	l, err := New(3, func(k, v int) {
+		evicted = append(evicted, k)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if l.Pin(1) || l.Unpin(1) {
+		t.Fatalf("missing key should not be pinned")
+	}
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	if !l.Pin(1) || !l.Pin(2) {
+		t.Fatalf("keys should be pinned")
+	}
+	for i := 4; i < 10; i++ {
+		l.Add(i, i)
+	}
+	if fmt.Sprint(l.Keys()) != "[1 2 9]" {
+		t.Fatalf("pinned keys should stay: %v", l.Keys())
+	}
+	if fmt.Sprint(evicted) != "[3 4 5 6 7 8]" {
+		t.Fatalf("bad evicted: %v", evicted)
+	}
+	// only pinned keys left, the newest is evicted to stay in size
+	l.Pin(9)
+	if !l.Add(10, 10) || l.Contains(10) || l.Len() != 3 {
+		t.Fatalf("unpinned key should be evicted: %v", l.Keys())
+	}
+	if l.Resize(1) != 0 || l.Len() != 3 {
+		t.Fatalf("resize should not evict pinned keys: %v", l.Keys())
+	}
+	evicted = evicted[:0]
+	if !l.Unpin(1) || !l.Unpin(9) {
+		t.Fatalf("keys should be unpinned")
+	}
+	if fmt.Sprint(l.Keys()) != "[2]" || fmt.Sprint(evicted) != "[1 9]" {
+		t.Fatalf("unpinned keys should be evicted: %v, %v", l.Keys(), evicted)
+	}
+	// explicit removal still works
+	if !l.Remove(2) || l.Len() != 0 {
+		t.Fatalf("pinned key should be removed")
+	}
+}
+
+// test that iteration walks both ways, stops early and keeps recency
+func TestLRU_Each(t *testing.T) {
+	l, err := New[int, int](8, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := l.Each(func(k, v int) error { return errors.New("empty") }); err != nil {
+		t.Fatalf("empty cache should not call fn")
+	}
+	for i := 0; i < 5; i++ {
+		l.Add(i, i*10)
+	}
+	l.AddWithTTL(5, 50, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	l.Get(0)
+
+	keys := []int{}
+	err = l.Each(func(k, v int) error {
+		if v != k*10 {
+			t.Fatalf("bad value: %v", v)
+		}
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil || fmt.Sprint(keys) != "[1 2 3 4 0]" {
+		t.Fatalf("bad keys: %v, %v", keys, err)
+	}
+	keys = keys[:0]
+	stop := errors.New("stop")
+	err = l.EachReverse(func(k, v int) error {
+		keys = append(keys, k)
+		if k == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || fmt.Sprint(keys) != "[0 4 3]" {
+		t.Fatalf("bad keys: %v, %v", keys, err)
+	}
+	if k, _, _ := l.GetOldest(); k != 1 {
+		t.Fatalf("iteration should not change recency: %v", k)
+	}
+
+	s, err := NewSharded[int, int](4, 64, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 32; i++ {
+		s.Add(i, i)
+	}
+	n := 0
+	s.EachReverse(func(k, v int) error {
+		n++
+		return nil
+	})
+	if n != 32 {
+		t.Fatalf("bad count: %v", n)
+	}
+}
+
+func TestShardedLRU(t *testing.T) {
+	var mu sync.Mutex
+	evictCounter := 0
+	onEvicted := func(k int, v int) {
+		if k != v {
+			t.Errorf("Evict values not equal (%v!=%v)", k, v)
+		}
+		mu.Lock()
+		evictCounter++
+		mu.Unlock()
+	}
+	l, err := NewSharded(4, 128, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 256; i < (g+1)*256; i++ {
+				l.Add(i, i)
+				l.Get(i)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if l.Len() > 128 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if evictCounter != 1024-l.Len() {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+	keys := l.Keys()
+	if len(keys) != l.Len() {
+		t.Fatalf("bad keys: %v", len(keys))
+	}
+	for _, k := range keys {
+		if v, ok := l.Peek(k); !ok || v != k {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+
+	k, _, ok := l.GetOldest()
+	if !ok {
+		t.Fatalf("missing")
+	}
+	if rk, _, ok := l.RemoveOldest(); !ok || rk != k {
+		t.Fatalf("bad: %v", rk)
+	}
+	if l.Contains(k) {
+		t.Fatalf("%v should be removed", k)
+	}
+
+	before := l.Len()
+	if evicted := l.Resize(64); evicted != before-l.Len() || l.Len() > 64 {
+		t.Fatalf("bad resize: %v, len %v", evicted, l.Len())
+	}
+
+	l.Purge()
+	if l.Len() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+}
+
+func TestShardedLRU_Hash(t *testing.T) {
+	type key struct {
+		a string
+		b int
+	}
+	if _, err := NewShardedWithConfig(8, Config[key, int]{Size: 64}); err == nil {
+		t.Fatalf("should fail without a hash for struct keys")
+	}
+	l, err := NewShardedWithConfig(8, Config[key, int]{
+		Size: 64,
+		Hash: func(k key) uint64 { return uint64(k.b) },
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		l.Add(key{"k", i}, i)
+	}
+	for i := 0; i < 8; i++ {
+		if v, ok := l.Get(key{"k", i}); !ok || v != i {
+			t.Fatalf("bad key: %v", i)
+		}
+	}
+	if _, err := NewSharded[int, int](8, 4, nil); err == nil {
+		t.Fatalf("should fail with fewer entries than shards")
+	}
+
+	type id string
+	s, _ := NewSharded[id, int](4, 64, nil)
+	s.Add("a", 1)
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Fatalf("bad named key: %v", v)
+	}
+	f, _ := NewSharded[float64, int](64, 64, nil)
+	f.Add(math.Copysign(0, -1), 1)
+	if v, ok := f.Get(0); !ok || v != 1 {
+		t.Fatalf("-0 and +0 should be the same key")
+	}
+}
+
+func TestShardedLRU_Size(t *testing.T) {
+	l, err := NewSharded[int, int](4, 10, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() > 10 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	l.Resize(6)
+	for i := 0; i < 1000; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() > 6 {
+		t.Fatalf("bad len after resize: %v", l.Len())
+	}
+}
+
+func TestShardedLRU_OnShardEvicted(t *testing.T) {
+	var mu sync.Mutex
+	evicted := map[int]int{}
+	l, err := NewShardedWithConfig(4, Config[int, int]{
+		Size: 4,
+		OnShardEvicted: func(shard, k, v int, r EvictReason) {
+			mu.Lock()
+			evicted[shard]++
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		l.Add(i, i)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	n := 0
+	for shard, c := range evicted {
+		if shard < 0 || shard >= 4 {
+			t.Fatalf("bad shard: %v", shard)
+		}
+		n += c
+	}
+	if n != 100-l.Len() || len(evicted) != 4 {
+		t.Fatalf("bad evictions: %v", evicted)
+	}
+}
+
 func getRand(tb testing.TB) int64 {
 	out, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
@@ -549,5 +1526,3 @@ func getRand(tb testing.TB) int64 {
 	}
 	return out.Int64()
 }
-
-
@@ -0,0 +1,347 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbluth/go/pkg/lru"
+	"github.com/cbluth/go/pkg/mtls"
+)
+
+const (
+	// BasePath prefixes the keys served by the peer API
+	BasePath = "/_lru/"
+	// DefaultTimeout bounds a request to another peer
+	DefaultTimeout = 5 * time.Second
+	// DefaultMaxValueSize bounds the value of a PUT
+	DefaultMaxValueSize = 1 << 20
+)
+
+var (
+	_ Node = &node{}
+
+	errSelf    = fmt.Errorf("peer: missing self url")
+	errSecret  = fmt.Errorf("peer: missing secret")
+	errNoPeers = fmt.Errorf("peer: self is not one of the peers")
+)
+
+type (
+	// Node is one peer of a distributed cache. Keys are spread over the
+	// peers with a Ring and every peer keeps the keys it owns in its own
+	// lru, so the peers together act as one cache. Peers talk to each
+	// other over HTTP with mutual TLS, authenticated by a shared secret.
+	Node interface {
+		// ServeHTTP serves the peer API under BasePath:
+		// GET, PUT and DELETE of BasePath + url.PathEscape(key).
+		// PUT takes the value as body and an optional ttl query
+		// parameter in time.ParseDuration format.
+		http.Handler
+		// Get returns the value of key from the peer owning it
+		Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+		// Set stores a value on the peer owning key, zero ttl uses
+		// the default TTL of that peer's cache
+		Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+		// Delete removes key from the peer owning it
+		Delete(ctx context.Context, key string) error
+		// Owner returns the url of the peer owning key
+		Owner(key string) string
+		// SetPeers replaces the peers, they must include this node
+		SetPeers(peers ...string) error
+		// Peers returns the urls of the peers, sorted
+		Peers() []string
+		// Cache returns the lru holding the keys this node owns
+		Cache() lru.LRU[string, []byte]
+		// Server sets up server to serve the peer API over mutual TLS
+		Server(server *http.Server) (*http.Server, error)
+		// Stop stops the background work of the cache
+		Stop()
+	}
+	// Config configures a Node.
+	Config struct {
+		// Self is the url other peers reach this node on, eg.
+		// https://cache-1.internal:8443, its hostname is put in the
+		// certificate of the node
+		Self string
+		// Peers are the urls of every node, including Self
+		Peers []string
+		// Secret is shared by all peers, it seeds their certificate authority
+		Secret []byte
+		// Replicas is the number of ring points per peer,
+		// DefaultReplicas if zero
+		Replicas int
+		// Timeout bounds a request to another peer,
+		// DefaultTimeout if zero
+		Timeout time.Duration
+		// MaxValueSize bounds the value a peer can PUT,
+		// DefaultMaxValueSize if zero
+		MaxValueSize int64
+		// Cache configures the lru of the node
+		Cache lru.Config[string, []byte]
+	}
+	node struct {
+		self    string
+		secret  []byte
+		timeout time.Duration
+		maxSize int64
+		ring    Ring
+		cache   lru.LRU[string, []byte]
+		clients map[string]*http.Client
+		m       sync.RWMutex
+	}
+)
+
+// New returns a node of a distributed cache.
+func New(cfg Config) (Node, error) {
+	if cfg.Self == "" {
+		return nil, errSelf
+	}
+	if len(cfg.Secret) == 0 {
+		return nil, errSecret
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxValueSize <= 0 {
+		cfg.MaxValueSize = DefaultMaxValueSize
+	}
+	cache, err := lru.NewWithConfig(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{
+		self:    cfg.Self,
+		secret:  cfg.Secret,
+		timeout: cfg.Timeout,
+		maxSize: cfg.MaxValueSize,
+		ring:    NewRing(cfg.Replicas),
+		cache:   cache,
+		clients: map[string]*http.Client{},
+	}
+	peers := cfg.Peers
+	if len(peers) == 0 {
+		peers = []string{cfg.Self}
+	}
+	if err := n.SetPeers(peers...); err != nil {
+		cache.Stop()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *node) SetPeers(peers ...string) error {
+	self := false
+	clients := map[string]*http.Client{}
+	n.m.RLock()
+	for _, p := range peers {
+		if p == n.self {
+			self = true
+			continue
+		}
+		if c, has := n.clients[p]; has {
+			clients[p] = c
+		}
+	}
+	n.m.RUnlock()
+	if !self {
+		return errNoPeers
+	}
+	for _, p := range peers {
+		if _, has := clients[p]; has || p == n.self {
+			continue
+		}
+		c, err := mtls.NewHTTPClient(n.secret, p, &http.Client{Timeout: n.timeout})
+		if err != nil {
+			return fmt.Errorf("peer %s: %v", p, err)
+		}
+		clients[p] = c
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.clients = clients
+	n.ring.Set(peers...)
+	return nil
+}
+
+func (n *node) Peers() []string {
+	return n.ring.Peers()
+}
+
+func (n *node) Owner(key string) string {
+	return n.ring.Get(key)
+}
+
+func (n *node) Cache() lru.LRU[string, []byte] {
+	return n.cache
+}
+
+func (n *node) Stop() {
+	n.cache.Stop()
+}
+
+func (n *node) Server(server *http.Server) (*http.Server, error) {
+	server.Handler = n
+	return mtls.NewHTTPServer(n.secret, n.self, server)
+}
+
+// client returns the owner of key and a client for it,
+// a nil client if this node owns key.
+func (n *node) client(key string) (string, *http.Client) {
+	n.m.RLock()
+	defer n.m.RUnlock()
+	owner := n.ring.Get(key)
+	if owner == n.self {
+		return owner, nil
+	}
+	return owner, n.clients[owner]
+}
+
+func (n *node) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	owner, c := n.client(key)
+	if c == nil {
+		v, ok := n.cache.Get(key)
+		return bytes.Clone(v), ok, nil
+	}
+	resp, err := n.do(ctx, c, http.MethodGet, owner, key, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		v, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, false, fmt.Errorf("peer %s: %v", owner, err)
+		}
+		return v, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	}
+	return nil, false, statusError(owner, resp)
+}
+
+func (n *node) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	owner, c := n.client(key)
+	if c == nil {
+		n.add(key, bytes.Clone(value), ttl)
+		return nil
+	}
+	q := url.Values{}
+	if ttl > 0 {
+		q.Set("ttl", ttl.String())
+	}
+	resp, err := n.do(ctx, c, http.MethodPut, owner, key, q, value)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return statusError(owner, resp)
+	}
+	return nil
+}
+
+func (n *node) Delete(ctx context.Context, key string) error {
+	owner, c := n.client(key)
+	if c == nil {
+		n.cache.Remove(key)
+		return nil
+	}
+	resp, err := n.do(ctx, c, http.MethodDelete, owner, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return statusError(owner, resp)
+	}
+	return nil
+}
+
+// add stores a value in the cache of this node.
+func (n *node) add(key string, value []byte, ttl time.Duration) {
+	if ttl > 0 {
+		n.cache.AddWithTTL(key, value, ttl)
+	} else {
+		n.cache.Add(key, value)
+	}
+}
+
+// do sends a request for key to owner.
+func (n *node) do(ctx context.Context, c *http.Client, method, owner, key string, q url.Values, body []byte) (*http.Response, error) {
+	query := ""
+	if len(q) > 0 {
+		query = "?" + q.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	u := strings.TrimSuffix(owner, "/") + BasePath + url.PathEscape(key) + query
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("peer %s: %v", owner, err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("peer %s: %v", owner, err)
+	}
+	return resp, nil
+}
+
+// statusError reads the error a peer answered with.
+func statusError(owner string, resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("peer %s: %s: %s", owner, resp.Status, strings.TrimSpace(string(b)))
+}
+
+func (n *node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, BasePath)
+	if key == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		v, ok := n.cache.Get(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(v)
+	case http.MethodPut:
+		var ttl time.Duration
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				http.Error(w, "bad ttl", http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		v, err := io.ReadAll(http.MaxBytesReader(w, r.Body, n.maxSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		n.add(key, v, ttl)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		n.cache.Remove(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,242 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbluth/go/pkg/lru"
+	"github.com/cbluth/go/pkg/mtls"
+)
+
+var (
+	testSecret = []byte("peer test secret")
+)
+
+// startPeers runs n nodes on loopback, stopped when the test ends
+func startPeers(t *testing.T, n int) ([]Node, []string) {
+	t.Helper()
+	listeners := make([]net.Listener, n)
+	urls := make([]string, n)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		listeners[i] = l
+		urls[i] = fmt.Sprintf("https://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	}
+	nodes := make([]Node, n)
+	for i := range nodes {
+		node, err := New(Config{
+			Self:   urls[i],
+			Peers:  urls,
+			Secret: testSecret,
+			Cache:  lru.Config[string, []byte]{Size: 128},
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		srv, err := node.Server(&http.Server{})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		go srv.ServeTLS(listeners[i], "", "")
+		t.Cleanup(func() {
+			srv.Close()
+			node.Stop()
+		})
+		nodes[i] = node
+	}
+	return nodes, urls
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(0)
+	if r.Get("a") != "" {
+		t.Fatalf("empty ring should own nothing")
+	}
+	r.Set("b", "a", "c", "a", "")
+	if r.Len() != 3 || strings.Join(r.Peers(), ",") != "a,b,c" {
+		t.Fatalf("bad peers: %v", r.Peers())
+	}
+	owners := map[string]string{}
+	count := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprint(i)
+		owners[k] = r.Get(k)
+		count[owners[k]]++
+	}
+	for _, p := range r.Peers() {
+		if count[p] < 500 {
+			t.Fatalf("keys should be spread over the peers: %v", count)
+		}
+	}
+	// the same peers in any order give the same owners
+	if NewRing(0, "c", "a", "b").Get("42") != owners["42"] {
+		t.Fatalf("ring should not depend on the order of peers")
+	}
+	// only the keys of a removed peer move
+	r.Set("a", "b")
+	for k, o := range owners {
+		if o != "c" && r.Get(k) != o {
+			t.Fatalf("key %v moved from %v to %v", k, o, r.Get(k))
+		}
+		if r.Get(k) == "c" {
+			t.Fatalf("removed peer should own nothing")
+		}
+	}
+}
+
+func TestNode(t *testing.T) {
+	if _, err := New(Config{Secret: testSecret}); err == nil {
+		t.Fatalf("should fail without self")
+	}
+	if _, err := New(Config{Self: "https://localhost:1"}); err == nil {
+		t.Fatalf("should fail without secret")
+	}
+	if _, err := New(Config{Self: "https://localhost:1", Peers: []string{"https://localhost:2"}, Secret: testSecret}); err == nil {
+		t.Fatalf("should fail without self in peers")
+	}
+
+	ctx := context.Background()
+	nodes, _ := startPeers(t, 3)
+	keys := []string{"a", "b/c", "d?e", "f g", "%", "🙂"}
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprint("key", i))
+	}
+	for i, k := range keys {
+		// write through any node
+		if err := nodes[i%3].Set(ctx, k, []byte("v"+k), 0); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for i, node := range nodes {
+		for _, k := range keys {
+			v, ok, err := node.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !ok || string(v) != "v"+k {
+				t.Fatalf("node %d: bad value for %q: %q %v", i, k, v, ok)
+			}
+		}
+	}
+	// every key is stored once, on its owner
+	total := 0
+	for _, node := range nodes {
+		for _, k := range node.Cache().Keys() {
+			if node.Owner(k) != nodes[0].Owner(k) {
+				t.Fatalf("nodes should agree on the owner of %q", k)
+			}
+		}
+		total += node.Cache().Len()
+	}
+	if total != len(keys) {
+		t.Fatalf("keys should be stored once: %v", total)
+	}
+
+	if err := nodes[1].Delete(ctx, "b/c"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, node := range nodes {
+		if _, ok, err := node.Get(ctx, "b/c"); ok || err != nil {
+			t.Fatalf("key should be deleted: %v %v", ok, err)
+		}
+	}
+
+	// ttl is sent to the owner
+	if err := nodes[2].Set(ctx, "ttl", []byte("x"), time.Millisecond); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, err := nodes[0].Get(ctx, "ttl"); ok || err != nil {
+		t.Fatalf("key should be expired: %v %v", ok, err)
+	}
+}
+
+func TestNode_SetPeers(t *testing.T) {
+	ctx := context.Background()
+	nodes, urls := startPeers(t, 3)
+	for i := 0; i < 30; i++ {
+		if err := nodes[0].Set(ctx, fmt.Sprint(i), []byte{byte(i)}, 0); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := nodes[2].SetPeers(urls[:2]...); !errors.Is(err, errNoPeers) {
+		t.Fatalf("should fail without self in peers: %v", err)
+	}
+	// drop the last peer, only its keys are lost
+	for _, node := range nodes[:2] {
+		if err := node.SetPeers(urls[:2]...); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	lost := nodes[2].Cache().Len()
+	found := 0
+	for i := 0; i < 30; i++ {
+		k := fmt.Sprint(i)
+		if nodes[0].Owner(k) == urls[2] || nodes[1].Owner(k) != nodes[0].Owner(k) {
+			t.Fatalf("bad owner of %v: %v", k, nodes[0].Owner(k))
+		}
+		v, ok, err := nodes[1].Get(ctx, k)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if ok {
+			if len(v) != 1 || v[0] != byte(i) {
+				t.Fatalf("bad value for %v: %v", k, v)
+			}
+			found++
+		}
+	}
+	if found != 30-lost {
+		t.Fatalf("only the keys of the dropped peer should be lost: %v, %v", found, lost)
+	}
+}
+
+func TestNode_Secret(t *testing.T) {
+	_, urls := startPeers(t, 1)
+	self := urls[0]
+	c, err := mtls.NewHTTPClient([]byte("another secret"), self, &http.Client{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp, err := c.Get(self + BasePath + "a"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("a peer with another secret should be refused")
+	}
+	c, err = mtls.NewHTTPClient(testSecret, self, &http.Client{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := c.Get(self + BasePath + "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("bad status: %v", resp.Status)
+	}
+	req, _ := http.NewRequest(http.MethodPost, self+BasePath+"a", nil)
+	if resp, err = c.Do(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("bad status: %v", resp.Status)
+	}
+	req, _ = http.NewRequest(http.MethodPut, self+BasePath+"a", strings.NewReader(strings.Repeat("x", DefaultMaxValueSize+1)))
+	if resp, err = c.Do(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("bad status: %v", resp.Status)
+	}
+}
@@ -0,0 +1,120 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DefaultReplicas is the number of points each peer gets on the ring
+	DefaultReplicas = 64
+)
+
+var (
+	_ Ring = &ring{}
+)
+
+type (
+	// Ring is a consistent-hash ring mapping keys to peers.
+	// When a peer joins or leaves only the keys it owns move.
+	Ring interface {
+		// Set replaces the peers of the ring
+		Set(peers ...string)
+		// Get returns the peer owning key, empty if the ring is empty
+		Get(key string) string
+		// Peers returns the peers of the ring, sorted
+		Peers() []string
+		// Len returns the number of peers
+		Len() int
+	}
+	ring struct {
+		replicas int
+		points   []uint64
+		owners   map[uint64]string
+		peers    []string
+		m        sync.RWMutex
+	}
+)
+
+// NewRing returns a ring with replicas points per peer,
+// DefaultReplicas if replicas is not positive.
+func NewRing(replicas int, peers ...string) Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &ring{
+		replicas: replicas,
+		owners:   map[uint64]string{},
+	}
+	r.Set(peers...)
+	return r
+}
+
+// hashKey places a string on the ring.
+func hashKey(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func (r *ring) Set(peers ...string) {
+	points := []uint64{}
+	owners := map[uint64]string{}
+	uniq := map[string]struct{}{}
+	for _, p := range peers {
+		if _, has := uniq[p]; has || p == "" {
+			continue
+		}
+		uniq[p] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			h := hashKey(strconv.Itoa(i) + p)
+			if o, has := owners[h]; has && o < p {
+				// a collision goes to the lowest peer on every node
+				continue
+			}
+			if _, has := owners[h]; !has {
+				points = append(points, h)
+			}
+			owners[h] = p
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	sorted := make([]string, 0, len(uniq))
+	for p := range uniq {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.points = points
+	r.owners = owners
+	r.peers = sorted
+}
+
+func (r *ring) Get(key string) string {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func (r *ring) Peers() []string {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return append([]string{}, r.peers...)
+}
+
+func (r *ring) Len() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return len(r.peers)
+}
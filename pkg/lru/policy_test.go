@@ -0,0 +1,266 @@
+package lru
+
+import (
+	mrand "math/rand"
+	"testing"
+)
+
+// POLICIES
+////////////////////////////////////////////////////////////////
+
+// policies constructs every Cache policy with the same size
+var policies = []struct {
+	name string
+	new  func(size int, onEvicted func(k, v int64)) (Cache[int64, int64], error)
+}{
+	{"LRU", func(size int, onEvicted func(k, v int64)) (Cache[int64, int64], error) {
+		return New(size, onEvicted)
+	}},
+	{"ARC", NewARC[int64, int64]},
+	{"2Q", New2Q[int64, int64]},
+	{"LFU", func(size int, onEvicted func(k, v int64)) (Cache[int64, int64], error) {
+		return NewLFU(size, onEvicted)
+	}},
+}
+
+// tinyLFUPolicy admits by frequency, so it does not keep the newest keys
+// the way the policies above do
+var tinyLFUPolicy = struct {
+	name string
+	new  func(size int, onEvicted func(k, v int64)) (Cache[int64, int64], error)
+}{"TinyLFU", NewTinyLFU[int64, int64]}
+
+// scanTrace is a hot set of keys interrupted by long scans of keys used once
+func scanTrace(n int) []int64 {
+	r := mrand.New(mrand.NewSource(1))
+	trace := make([]int64, 0, n)
+	next := int64(1 << 20)
+	for len(trace) < n {
+		for i := 0; i < 500; i++ {
+			trace = append(trace, r.Int63n(128))
+		}
+		for i := 0; i < 400; i++ {
+			trace = append(trace, next)
+			next++
+		}
+	}
+	return trace[:n]
+}
+
+// randTrace is uniformly random over twice the cache size
+func randTrace(n int) []int64 {
+	r := mrand.New(mrand.NewSource(1))
+	trace := make([]int64, n)
+	for i := range trace {
+		trace[i] = r.Int63n(512)
+	}
+	return trace
+}
+
+// freqTrace mixes a frequent half of the key space with the whole of it
+func freqTrace(n int) []int64 {
+	r := mrand.New(mrand.NewSource(1))
+	trace := make([]int64, n)
+	for i := range trace {
+		if i%2 == 0 {
+			trace[i] = r.Int63n(256)
+		} else {
+			trace[i] = r.Int63n(1024)
+		}
+	}
+	return trace
+}
+
+// replay looks up every key of trace, adding it on a miss
+func replay(l Cache[int64, int64], trace []int64) (ratio float64) {
+	var hit, miss int
+	for _, k := range trace {
+		if _, ok := l.Get(k); ok {
+			hit++
+		} else {
+			miss++
+			l.Add(k, k)
+		}
+	}
+	return float64(hit) / float64(hit+miss)
+}
+
+func TestPolicies_HitRatio(t *testing.T) {
+	traces := []struct {
+		name  string
+		trace []int64
+	}{
+		{"scan", scanTrace(100000)},
+		{"rand", randTrace(100000)},
+		{"freq", freqTrace(100000)},
+	}
+	for _, tr := range traces {
+		ratios := map[string]float64{}
+		for _, p := range append(policies, tinyLFUPolicy) {
+			l, err := p.new(256, nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			ratios[p.name] = replay(l, tr.trace)
+			if l.Len() > 256 {
+				t.Errorf("%s %s: bad len: %v", tr.name, p.name, l.Len())
+			}
+			t.Logf("%s %s: hit ratio %f", tr.name, p.name, ratios[p.name])
+		}
+		switch tr.name {
+		case "scan":
+			if ratios["ARC"] <= ratios["LRU"] || ratios["2Q"] <= ratios["LRU"] || ratios["TinyLFU"] <= ratios["LRU"] {
+				t.Errorf("scan resistant policies should beat LRU: %v", ratios)
+			}
+		default:
+			for name, ratio := range ratios {
+				if ratio < ratios["LRU"]*0.9 {
+					t.Errorf("%s %s: hit ratio too low: %v", tr.name, name, ratios)
+				}
+			}
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	for _, p := range policies {
+		evictCounter := 0
+		onEvicted := func(k, v int64) {
+			if k != v {
+				t.Fatalf("%s: Evict values not equal (%v!=%v)", p.name, k, v)
+			}
+			evictCounter++
+		}
+		l, err := p.new(128, onEvicted)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := p.new(0, nil); err == nil {
+			t.Fatalf("%s: should fail with size 0", p.name)
+		}
+
+		for i := int64(0); i < 256; i++ {
+			l.Add(i, i)
+		}
+		if l.Len() != 128 {
+			t.Fatalf("%s: bad len: %v", p.name, l.Len())
+		}
+		if evictCounter != 128 {
+			t.Fatalf("%s: bad evict count: %v", p.name, evictCounter)
+		}
+		for _, k := range l.Keys() {
+			if v, ok := l.Get(k); !ok || v != k {
+				t.Fatalf("%s: bad key: %v", p.name, k)
+			}
+		}
+		for i := int64(0); i < 128; i++ {
+			if _, ok := l.Peek(i); ok {
+				t.Fatalf("%s: should be evicted", p.name)
+			}
+		}
+
+		// Contains and Peek don't promote
+		if !l.Contains(200) {
+			t.Fatalf("%s: 200 should be contained", p.name)
+		}
+		if v, ok := l.Peek(200); !ok || v != 200 {
+			t.Fatalf("%s: 200 should be set to 200: %v, %v", p.name, v, ok)
+		}
+		if ok, evicted := l.ContainsOrAdd(200, 200); !ok || evicted {
+			t.Fatalf("%s: 200 should be contained", p.name)
+		}
+		if prev, ok, evicted := l.PeekOrAdd(300, 300); ok || !evicted || prev != 0 {
+			t.Fatalf("%s: 300 should have been added", p.name)
+		}
+
+		if !l.Remove(200) || l.Remove(200) || l.Contains(200) {
+			t.Fatalf("%s: 200 should be removed once", p.name)
+		}
+		before := evictCounter
+		if evicted := l.Resize(64); evicted != 63 || l.Len() != 64 {
+			t.Fatalf("%s: bad resize: %v, len %v", p.name, evicted, l.Len())
+		}
+		if evictCounter-before != 63 {
+			t.Fatalf("%s: onEvicted should have been called 63 times: %v", p.name, evictCounter-before)
+		}
+		l.Purge()
+		if l.Len() != 0 || len(l.Keys()) != 0 {
+			t.Fatalf("%s: bad len: %v", p.name, l.Len())
+		}
+		if _, ok := l.Get(300); ok {
+			t.Fatalf("%s: should contain nothing", p.name)
+		}
+	}
+}
+
+func TestTinyLFU(t *testing.T) {
+	evicted := map[int]int{}
+	l, err := NewTinyLFU(100, func(k, v int) {
+		if k != v {
+			t.Fatalf("Evict values not equal (%v!=%v)", k, v)
+		}
+		evicted[k]++
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// make 0..49 popular
+	for r := 0; r < 4; r++ {
+		for i := 0; i < 50; i++ {
+			if _, ok := l.Get(i); !ok {
+				l.Add(i, i)
+			}
+		}
+	}
+	// a scan of keys used once must not flush them
+	for i := 1000; i < 2000; i++ {
+		l.Add(i, i)
+	}
+	if l.Len() != 100 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if len(evicted) != 1000-50 {
+		t.Fatalf("bad evict count: %v", len(evicted))
+	}
+	for i := 0; i < 50; i++ {
+		if v, ok := l.Peek(i); !ok || v != i {
+			t.Fatalf("popular key %v should not be evicted", i)
+		}
+	}
+	if len(l.Keys()) != 100 {
+		t.Fatalf("bad keys: %v", len(l.Keys()))
+	}
+
+	if ok, ev := l.ContainsOrAdd(10, 10); !ok || ev {
+		t.Fatalf("10 should be contained")
+	}
+	if prev, ok, _ := l.PeekOrAdd(10, 11); !ok || prev != 10 {
+		t.Fatalf("10 should be set to 10: %v", prev)
+	}
+	if !l.Remove(10) || l.Contains(10) || evicted[10] != 1 {
+		t.Fatalf("10 should be removed")
+	}
+
+	if n := l.Resize(40); n != 59 || l.Len() != 40 {
+		t.Fatalf("bad resize: %v, len %v", n, l.Len())
+	}
+	// the sketch follows the size
+	sketch := l.(*tinyLFU[int, int]).sketch
+	if len(sketch.rows[0]) != 256 || sketch.sampleSize != 400 {
+		t.Fatalf("bad sketch: width %v, sample %v", len(sketch.rows[0]), sketch.sampleSize)
+	}
+	l.Resize(4000)
+	if len(sketch.rows[0]) != 16384 || sketch.sampleSize != 40000 {
+		t.Fatalf("bad sketch: width %v, sample %v", len(sketch.rows[0]), sketch.sampleSize)
+	}
+	l.Get(1)
+	l.Resize(4001)
+	if sketch.estimate(l.(*tinyLFU[int, int]).hash(1)) == 0 {
+		t.Fatalf("same width should keep the counters")
+	}
+	l.Purge()
+	if l.Len() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+}
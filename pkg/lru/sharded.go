@@ -0,0 +1,319 @@
+package lru
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	_ LRU[int, int] = &sharded[int, int]{}
+
+	// hashSeed seeds the default key hash for this process
+	hashSeed = maphash.MakeSeed()
+)
+
+// sharded is a thread-safe LRU cache that spreads keys across
+// independent lru shards, so operations on different shards never
+// contend on the same lock.
+type sharded[K comparable, V any] struct {
+	shards []*lru[K, V]
+	hash   func(K) uint64
+}
+
+// NewSharded constructs a cache of the given total size split across n
+// shards, each with its own lock and deferred eviction callback.
+func NewSharded[K comparable, V any](n, size int, onEvicted func(key K, value V)) (LRU[K, V], error) {
+	cfg := Config[K, V]{Size: size}
+	if onEvicted != nil {
+		cfg.OnEvicted = func(k K, v V, _ EvictReason) {
+			onEvicted(k, v)
+		}
+	}
+	return NewShardedWithConfig(n, cfg)
+}
+
+// NewShardedWithConfig constructs a cache split across n shards, where
+// cfg.Size is the total size and every shard shares the rest of cfg.
+// Keys that are not strings, numbers or booleans need a cfg.Hash.
+func NewShardedWithConfig[K comparable, V any](n int, cfg Config[K, V]) (LRU[K, V], error) {
+	if n <= 0 {
+		return nil, errors.New("must provide a positive number of shards")
+	}
+	if cfg.Size < n {
+		return nil, errors.New("must provide a size of at least one per shard")
+	}
+	c := &sharded[K, V]{
+		shards: make([]*lru[K, V], n),
+		hash:   cfg.Hash,
+	}
+	if c.hash == nil {
+		if c.hash = basicHash[K](); c.hash == nil {
+			return nil, errors.New("must provide a hash for this key type")
+		}
+	}
+	// every shard publishes to the same subscribers
+	events := &eventHub[K, V]{}
+	for i := range c.shards {
+		sc := cfg
+		sc.Size = shardSize(cfg.Size, n, i)
+		sc.OnEvicted = shardEvicted(i, cfg.OnEvicted, cfg.OnShardEvicted)
+		s, err := newLRU(sc, events)
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		c.shards[i] = s
+	}
+	return c, nil
+}
+
+// shardSize returns the share of shard i of size split across n shards,
+// the first size%n shards take one more.
+func shardSize(size, n, i int) int {
+	if i < size%n {
+		return size/n + 1
+	}
+	return size / n
+}
+
+// shardEvicted returns the eviction callback of shard i, calling both
+// onEvicted and onShardEvicted when set.
+func shardEvicted[K comparable, V any](i int, onEvicted func(K, V, EvictReason), onShardEvicted func(int, K, V, EvictReason)) func(K, V, EvictReason) {
+	switch {
+	case onShardEvicted == nil:
+		return onEvicted
+	case onEvicted == nil:
+		return func(k K, v V, r EvictReason) {
+			onShardEvicted(i, k, v, r)
+		}
+	default:
+		return func(k K, v V, r EvictReason) {
+			onEvicted(k, v, r)
+			onShardEvicted(i, k, v, r)
+		}
+	}
+}
+
+// shard returns the shard owning key.
+func (c *sharded[K, V]) shard(key K) *lru[K, V] {
+	return c.shards[c.hash(key)%uint64(len(c.shards))]
+}
+
+// Add adds a value to the cache. Returns true if an eviction occurred.
+func (c *sharded[K, V]) Add(key K, value V) bool {
+	return c.shard(key).Add(key, value)
+}
+
+// AddWithTTL adds a value to the cache that expires after ttl.
+// Returns true if an eviction occurred.
+func (c *sharded[K, V]) AddWithTTL(key K, value V, ttl time.Duration) bool {
+	return c.shard(key).AddWithTTL(key, value, ttl)
+}
+
+// Get looks up a key's value from the cache.
+func (c *sharded[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness or deleting it for being stale.
+func (c *sharded[K, V]) Contains(key K) bool {
+	return c.shard(key).Contains(key)
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *sharded[K, V]) Peek(key K) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *sharded[K, V]) Remove(key K) bool {
+	return c.shard(key).Remove(key)
+}
+
+// RemoveOldest removes the oldest item of the first non-empty shard.
+// Recency is only tracked per shard, so this approximates the global
+// oldest entry.
+func (c *sharded[K, V]) RemoveOldest() (key K, value V, ok bool) {
+	for _, s := range c.shards {
+		if key, value, ok = s.RemoveOldest(); ok {
+			return
+		}
+	}
+	return
+}
+
+// GetOldest returns the oldest entry of the first non-empty shard.
+// Recency is only tracked per shard, so this approximates the global
+// oldest entry.
+func (c *sharded[K, V]) GetOldest() (key K, value V, ok bool) {
+	for _, s := range c.shards {
+		if key, value, ok = s.GetOldest(); ok {
+			return
+		}
+	}
+	return
+}
+
+// Keys returns a slice of the keys in the cache, shard by shard and from
+// oldest to newest within each shard.
+func (c *sharded[K, V]) Keys() []K {
+	keys := make([]K, 0, c.Len())
+	for _, s := range c.shards {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+// Pin keeps a key in its shard when the shard is full.
+func (c *sharded[K, V]) Pin(key K) bool {
+	return c.shard(key).Pin(key)
+}
+
+// Unpin lets a pinned key be evicted from its shard again.
+func (c *sharded[K, V]) Unpin(key K) bool {
+	return c.shard(key).Unpin(key)
+}
+
+// Each calls fn for every entry, shard by shard and from oldest to
+// newest within each shard, until fn fails.
+func (c *sharded[K, V]) Each(fn func(key K, value V) error) error {
+	for _, s := range c.shards {
+		if err := s.Each(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachReverse calls fn for every entry, shard by shard and from newest to
+// oldest within each shard, until fn fails.
+func (c *sharded[K, V]) EachReverse(fn func(key K, value V) error) error {
+	for _, s := range c.shards {
+		if err := s.EachReverse(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in the cache.
+func (c *sharded[K, V]) Len() (length int) {
+	for _, s := range c.shards {
+		length += s.Len()
+	}
+	return length
+}
+
+// Cost returns the total weight of the items in the cache.
+func (c *sharded[K, V]) Cost() (cost int) {
+	for _, s := range c.shards {
+		cost += s.Cost()
+	}
+	return cost
+}
+
+// Stats returns the sum of the counters of every shard.
+func (c *sharded[K, V]) Stats() (stats Stats) {
+	for _, s := range c.shards {
+		stats = stats.add(s.Stats())
+	}
+	return stats
+}
+
+// Subscribe returns a channel receiving the evictions of every shard.
+func (c *sharded[K, V]) Subscribe(size int, policy DeliveryPolicy) (<-chan Event[K, V], func()) {
+	return c.shards[0].Subscribe(size, policy)
+}
+
+// Purge is used to completely clear the cache.
+func (c *sharded[K, V]) Purge() {
+	for _, s := range c.shards {
+		s.Purge()
+	}
+}
+
+// Resize changes the total cache size, returning the number evicted.
+func (c *sharded[K, V]) Resize(size int) (evicted int) {
+	if size < 0 {
+		size = 0
+	}
+	for i, s := range c.shards {
+		evicted += s.Resize(shardSize(size, len(c.shards), i))
+	}
+	return evicted
+}
+
+// ContainsOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *sharded[K, V]) ContainsOrAdd(key K, value V) (bool, bool) {
+	return c.shard(key).ContainsOrAdd(key, value)
+}
+
+// PeekOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *sharded[K, V]) PeekOrAdd(key K, value V) (V, bool, bool) {
+	return c.shard(key).PeekOrAdd(key, value)
+}
+
+// Stop stops the janitor of every shard.
+func (c *sharded[K, V]) Stop() {
+	for _, s := range c.shards {
+		if s != nil {
+			s.Stop()
+		}
+	}
+}
+
+// basicHash returns a hash for keys whose kind is a string, number or
+// boolean, named or not, and nil for any other key.
+func basicHash[K comparable]() func(K) uint64 {
+	switch reflect.TypeOf((*K)(nil)).Elem().Kind() {
+	case reflect.String:
+		return func(key K) uint64 {
+			return maphash.String(hashSeed, reflect.ValueOf(key).String())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(key K) uint64 {
+			return mix64(uint64(reflect.ValueOf(key).Int()))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(key K) uint64 {
+			return mix64(reflect.ValueOf(key).Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(key K) uint64 {
+			f := reflect.ValueOf(key).Float()
+			if f == 0 {
+				// -0 and +0 are the same key
+				f = 0
+			}
+			return mix64(math.Float64bits(f))
+		}
+	case reflect.Bool:
+		return func(key K) uint64 {
+			if reflect.ValueOf(key).Bool() {
+				return mix64(1)
+			}
+			return mix64(0)
+		}
+	}
+	return nil
+}
+
+// mix64 is the splitmix64 finalizer, spreading sequential integers
+// across the whole hash space.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,103 @@
+package lru
+
+const (
+	// cmDepth is the number of rows in the count-min sketch
+	cmDepth = 4
+	// cmMaxCount is where counters saturate
+	cmMaxCount = 15
+	// cmWidthFactor times the cache size is the number of counters per row
+	cmWidthFactor = 4
+	// cmSampleFactor times the cache size is how many increments happen
+	// before all counters are halved
+	cmSampleFactor = 10
+)
+
+// cmSketch is a count-min sketch estimating how often keys were seen.
+// Counters saturate at cmMaxCount and are halved periodically, so the
+// estimate favours recent popularity over all-time popularity.
+type cmSketch struct {
+	rows       [cmDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+// newCMSketch returns a sketch sized for a cache of size entries.
+func newCMSketch(size int) *cmSketch {
+	w := 16
+	for w < cmWidthFactor*size {
+		w <<= 1
+	}
+	s := &cmSketch{
+		mask:       uint64(w - 1),
+		sampleSize: cmSampleFactor * size,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// resize sizes the sketch for a cache of size entries. The counters are
+// kept if the width does not change, and cleared otherwise as they were
+// indexed by the old width.
+func (s *cmSketch) resize(size int) {
+	n := newCMSketch(size)
+	if n.mask != s.mask {
+		*s = *n
+		return
+	}
+	s.sampleSize = n.sampleSize
+	for s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+// index returns the counter of row i for a key hash.
+func (s *cmSketch) index(i int, h uint64) uint64 {
+	return mix64(h+uint64(i)*0x9e3779b97f4a7c15) & s.mask
+}
+
+// increment counts one more sighting of a key hash.
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		if idx := s.index(i, h); s.rows[i][idx] < cmMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+// estimate returns how often a key hash was seen, possibly overcounting.
+func (s *cmSketch) estimate(h uint64) uint8 {
+	min := uint8(cmMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(i, h)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// age halves every counter.
+func (s *cmSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// clear zeroes every counter.
+func (s *cmSketch) clear() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.additions = 0
+}
@@ -0,0 +1,213 @@
+package lru
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	// snapshotVersion is written in every snapshot header
+	snapshotVersion = 1
+)
+
+var (
+	// GobCodec writes snapshots with encoding/gob, it is the default.
+	GobCodec Codec = gobCodec{}
+	// JSONCodec writes snapshots as a stream of JSON values.
+	JSONCodec Codec = jsonCodec{}
+)
+
+type (
+	// Codec writes and reads the records of a snapshot.
+	Codec interface {
+		NewEncoder(io.Writer) Encoder
+		NewDecoder(io.Reader) Decoder
+	}
+	// Encoder writes one record at a time.
+	Encoder interface {
+		Encode(any) error
+	}
+	// Decoder reads one record at a time.
+	Decoder interface {
+		Decode(any) error
+	}
+
+	gobCodec  struct{}
+	jsonCodec struct{}
+
+	// snapshotHeader opens a snapshot, Len entries follow it
+	snapshotHeader struct {
+		Version int
+		Size    int
+		Len     int
+	}
+	// snapshotEntry is one entry of a snapshot, ExpiresAt is zero if it
+	// never expires, used is not written
+	snapshotEntry[K comparable, V any] struct {
+		Key       K
+		Value     V
+		ExpiresAt time.Time
+		used      time.Time
+	}
+)
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder  { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder  { return gob.NewDecoder(r) }
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+// writeSnapshot writes a header and entries with codec.
+func writeSnapshot[K comparable, V any](w io.Writer, codec Codec, size int, entries []snapshotEntry[K, V]) error {
+	enc := codec.NewEncoder(w)
+	err := enc.Encode(snapshotHeader{
+		Version: snapshotVersion,
+		Size:    size,
+		Len:     len(entries),
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: %v", err)
+	}
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return fmt.Errorf("snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// readSnapshot reads a header and its entries with codec, returning the
+// size of the snapshot cache and the entries.
+func readSnapshot[K comparable, V any](r io.Reader, codec Codec) (int, []snapshotEntry[K, V], error) {
+	dec := codec.NewDecoder(r)
+	h := snapshotHeader{}
+	if err := dec.Decode(&h); err != nil {
+		return 0, nil, fmt.Errorf("restore: %v", err)
+	}
+	if h.Version != snapshotVersion {
+		return 0, nil, fmt.Errorf("restore: unknown snapshot version %d", h.Version)
+	}
+	if h.Len < 0 {
+		return 0, nil, fmt.Errorf("restore: bad snapshot length %d", h.Len)
+	}
+	if h.Size <= 0 {
+		return 0, nil, fmt.Errorf("restore: bad snapshot size %d", h.Size)
+	}
+	entries := []snapshotEntry[K, V]{}
+	for i := 0; i < h.Len; i++ {
+		e := snapshotEntry[K, V]{}
+		if err := dec.Decode(&e); err != nil {
+			return 0, nil, fmt.Errorf("restore: entry %d: %v", i, err)
+		}
+		entries = append(entries, e)
+	}
+	return h.Size, entries, nil
+}
+
+// entries copies the entries from oldest to newest, skipping expired ones.
+func (c *cLRU[K, V]) entries(now time.Time) []snapshotEntry[K, V] {
+	entries := make([]snapshotEntry[K, V], 0, c.evictList.length())
+	for ent := c.evictList.back(); ent != nil; ent = ent.prevEntry() {
+		if ent.expired(now) {
+			continue
+		}
+		entries = append(entries, snapshotEntry[K, V]{
+			Key:       ent.key,
+			Value:     ent.value,
+			ExpiresAt: ent.expiresAt,
+			used:      ent.used,
+		})
+	}
+	return entries
+}
+
+// Snapshot writes the entries of the cache to w from oldest to newest,
+// along with the cache size, using the configured codec.
+func (c *lru[K, V]) Snapshot(w io.Writer) error {
+	c.RLock()
+	entries := c.lru.entries(time.Now())
+	size := c.lru.size
+	c.RUnlock()
+	return writeSnapshot(w, c.codec, size, entries)
+}
+
+// Restore reads a snapshot from r and adds its entries from oldest to
+// newest, so they come back in the same recency order with their
+// remaining time-to-live. The cache takes the size of the snapshot unless
+// Config.KeepSize is set, then when it is smaller than the snapshot the
+// oldest entries are evicted.
+func (c *lru[K, V]) Restore(r io.Reader) error {
+	size, entries, err := readSnapshot[K, V](r, c.codec)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	c.Lock()
+	if !c.keepSize {
+		c.lru.resize(size)
+	}
+	for _, e := range entries {
+		var ttl time.Duration
+		if !e.ExpiresAt.IsZero() {
+			if ttl = e.ExpiresAt.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		c.add(e.Key, e.Value, ttl)
+	}
+	ks, vs, rs := c.takeEvicted()
+	c.Unlock()
+	c.notify(ks, vs, rs)
+	return nil
+}
+
+// Snapshot writes the entries of every shard to w from oldest to newest
+// across the shards, ordered by the time they were last added or read, so
+// a cache that places keys in other shards can restore them. Entries of
+// different shards used in the same tick of the clock keep the order of
+// their shards.
+func (c *sharded[K, V]) Snapshot(w io.Writer) error {
+	entries := []snapshotEntry[K, V]{}
+	size := 0
+	now := time.Now()
+	for _, s := range c.shards {
+		s.RLock()
+		entries = append(entries, s.lru.entries(now)...)
+		size += s.lru.size
+		s.RUnlock()
+	}
+	// each shard is in order already
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+	return writeSnapshot(w, c.shards[0].codec, size, entries)
+}
+
+// Restore reads a snapshot from r and adds its entries to their shards
+// from oldest to newest, so each shard keeps their recency order even
+// when the shards of keys changed, as the default hash does from one
+// process to the next. The total size is the one of the snapshot unless
+// Config.KeepSize is set.
+func (c *sharded[K, V]) Restore(r io.Reader) error {
+	size, entries, err := readSnapshot[K, V](r, c.shards[0].codec)
+	if err != nil {
+		return err
+	}
+	if !c.shards[0].keepSize {
+		c.Resize(size)
+	}
+	now := time.Now()
+	for _, e := range entries {
+		var ttl time.Duration
+		if !e.ExpiresAt.IsZero() {
+			if ttl = e.ExpiresAt.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		c.shard(e.Key).AddWithTTL(e.Key, e.Value, ttl)
+	}
+	return nil
+}
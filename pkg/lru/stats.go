@@ -0,0 +1,182 @@
+package lru
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the counters of a cache.
+type Stats struct {
+	// Hits and Misses count Get lookups.
+	Hits   uint64
+	Misses uint64
+	// Adds counts new entries, Updates counts values replaced in place.
+	Adds    uint64
+	Updates uint64
+	// Evicted counts the entries that left the cache, by reason.
+	EvictedCapacity uint64
+	EvictedRemoved  uint64
+	EvictedPurged   uint64
+	EvictedResized  uint64
+	EvictedExpired  uint64
+	// Len and Cost are the number and total weight of entries.
+	Len  int
+	Cost int
+}
+
+// HitRatio returns the share of Get lookups that were hits.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Evictions returns the number of entries that left the cache.
+func (s Stats) Evictions() uint64 {
+	return s.EvictedCapacity + s.EvictedRemoved + s.EvictedPurged + s.EvictedResized + s.EvictedExpired
+}
+
+// add sums two snapshots.
+func (s Stats) add(o Stats) Stats {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Adds += o.Adds
+	s.Updates += o.Updates
+	s.EvictedCapacity += o.EvictedCapacity
+	s.EvictedRemoved += o.EvictedRemoved
+	s.EvictedPurged += o.EvictedPurged
+	s.EvictedResized += o.EvictedResized
+	s.EvictedExpired += o.EvictedExpired
+	s.Len += o.Len
+	s.Cost += o.Cost
+	return s
+}
+
+// counters are the live counters behind Stats, safe to bump under a
+// read lock.
+type counters struct {
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	adds    atomic.Uint64
+	updates atomic.Uint64
+	evicted [EvictExpired + 1]atomic.Uint64
+}
+
+// lookup counts a Get.
+func (c *counters) lookup(ok bool) {
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// add counts an Add, which may have replaced a value in place.
+func (c *counters) add(updated bool) {
+	if updated {
+		c.updates.Add(1)
+	} else {
+		c.adds.Add(1)
+	}
+}
+
+// evict counts n entries leaving the cache for reason r.
+func (c *counters) evict(r EvictReason, n int) {
+	if n > 0 {
+		c.evicted[r].Add(uint64(n))
+	}
+}
+
+// snapshot copies the counters.
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Hits:            c.hits.Load(),
+		Misses:          c.misses.Load(),
+		Adds:            c.adds.Load(),
+		Updates:         c.updates.Load(),
+		EvictedCapacity: c.evicted[EvictCapacity].Load(),
+		EvictedRemoved:  c.evicted[EvictRemoved].Load(),
+		EvictedPurged:   c.evicted[EvictPurged].Load(),
+		EvictedResized:  c.evicted[EvictResized].Load(),
+		EvictedExpired:  c.evicted[EvictExpired].Load(),
+	}
+}
+
+// StatsReporter is implemented by caches that count their use.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// StatsHandler serves the stats of caches, keyed by name, in the
+// Prometheus text exposition format.
+func StatsHandler(caches map[string]StatsReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(caches))
+		for name := range caches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		stats := make([]Stats, len(names))
+		for i, name := range names {
+			stats[i] = caches[name].Stats()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, names, stats)
+	})
+}
+
+// writeMetrics writes every metric family for the named stats.
+func writeMetrics(w io.Writer, names []string, stats []Stats) {
+	family := func(name, typ, help string, value func(Stats) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for i, s := range stats {
+			fmt.Fprintf(w, "%s{cache=\"%s\"} %s\n", name, escapeLabel(names[i]), value(s))
+		}
+	}
+	family("lru_hits_total", "counter", "Lookups that found a value.", func(s Stats) string {
+		return fmt.Sprint(s.Hits)
+	})
+	family("lru_misses_total", "counter", "Lookups that found no value.", func(s Stats) string {
+		return fmt.Sprint(s.Misses)
+	})
+	family("lru_adds_total", "counter", "Entries added.", func(s Stats) string {
+		return fmt.Sprint(s.Adds)
+	})
+	family("lru_updates_total", "counter", "Entries replaced in place.", func(s Stats) string {
+		return fmt.Sprint(s.Updates)
+	})
+	fmt.Fprintf(w, "# HELP lru_evictions_total Entries that left the cache.\n# TYPE lru_evictions_total counter\n")
+	for i, s := range stats {
+		for _, e := range []struct {
+			reason EvictReason
+			n      uint64
+		}{
+			{EvictCapacity, s.EvictedCapacity},
+			{EvictRemoved, s.EvictedRemoved},
+			{EvictPurged, s.EvictedPurged},
+			{EvictResized, s.EvictedResized},
+			{EvictExpired, s.EvictedExpired},
+		} {
+			fmt.Fprintf(w, "lru_evictions_total{cache=\"%s\",reason=\"%s\"} %d\n", escapeLabel(names[i]), e.reason, e.n)
+		}
+	}
+	family("lru_hit_ratio", "gauge", "Share of lookups that found a value.", func(s Stats) string {
+		return fmt.Sprint(s.HitRatio())
+	})
+	family("lru_entries", "gauge", "Entries in the cache.", func(s Stats) string {
+		return fmt.Sprint(s.Len)
+	})
+	family("lru_cost", "gauge", "Total weight of the entries in the cache.", func(s Stats) string {
+		return fmt.Sprint(s.Cost)
+	})
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
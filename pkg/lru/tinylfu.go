@@ -0,0 +1,311 @@
+package lru
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sync"
+)
+
+const (
+	// tinyLFUWindowRatio is the share of the cache given to the window
+	tinyLFUWindowRatio = 0.01
+	// tinyLFUProtectedRatio is the share of the main space given to
+	// protected entries
+	tinyLFUProtectedRatio = 0.80
+)
+
+var (
+	_ Cache[int, int] = &tinyLFU[int, int]{}
+)
+
+// tinyLFU is a thread-safe fixed size W-TinyLFU cache. New entries land
+// in a small window LRU, and when they leave it a count-min sketch
+// decides whether they are more popular than the entry the segmented
+// main LRU would evict for them. Only the more popular one stays.
+type tinyLFU[K comparable, V any] struct {
+	size          int
+	windowSize    int
+	protectedSize int
+
+	window    *cLRU[K, V] // recently added entries
+	probation *cLRU[K, V] // admitted entries used once since
+	protected *cLRU[K, V] // admitted entries used again
+
+	sketch *cmSketch
+	hash   func(K) uint64
+
+	evicted *evictBuffer[K, V]
+	sync.RWMutex
+}
+
+// NewTinyLFU constructs a fixed size W-TinyLFU cache with the given
+// eviction callback.
+func NewTinyLFU[K comparable, V any](size int, onEvicted func(key K, value V)) (Cache[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	c := &tinyLFU[K, V]{
+		sketch:  newCMSketch(size),
+		hash:    basicHash[K](),
+		evicted: newEvictBuffer(onEvicted),
+	}
+	if c.hash == nil {
+		c.hash = formatHash[K]
+	}
+	c.setSize(size)
+	// the lists never evict on their own, the admission policy decides
+	c.window, _ = NewcLRU(size, c.evicted.add)
+	c.probation, _ = NewcLRU(size, c.evicted.add)
+	c.protected, _ = NewcLRU(size, c.evicted.add)
+	return c, nil
+}
+
+// setSize splits size between the window and the main space.
+func (c *tinyLFU[K, V]) setSize(size int) {
+	c.size = size
+	c.windowSize = int(float64(size) * tinyLFUWindowRatio)
+	if c.windowSize < 1 {
+		c.windowSize = 1
+	}
+	c.protectedSize = int(float64(size-c.windowSize) * tinyLFUProtectedRatio)
+}
+
+// mainLen returns the number of admitted entries.
+func (c *tinyLFU[K, V]) mainLen() int {
+	return c.probation.len() + c.protected.len()
+}
+
+// Add adds a value to the cache. Returns true if an eviction occurred.
+func (c *tinyLFU[K, V]) Add(key K, value V) (evicted bool) {
+	h := c.hash(key)
+	c.Lock()
+	evicted = c.add(key, h, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// add adds a value to the window, or updates it where it already is.
+func (c *tinyLFU[K, V]) add(key K, h uint64, value V) (evicted bool) {
+	c.sketch.increment(h)
+	if c.window.contains(key) {
+		c.window.add(key, value)
+		return false
+	}
+	if c.protected.contains(key) {
+		c.protected.add(key, value)
+		return false
+	}
+	if _, ok := c.probation.detach(key); ok {
+		c.promote(key, value)
+		return false
+	}
+	c.window.add(key, value)
+	return c.evictWindow() > 0
+}
+
+// evictWindow moves entries over the window size towards the main space,
+// returning the number evicted.
+func (c *tinyLFU[K, V]) evictWindow() (evicted int) {
+	for c.window.len() > c.windowSize {
+		key, value, _ := c.window.detachOldest()
+		if c.admit(key, value) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// admit adds a candidate leaving the window to probation, if there is
+// room or it is more popular than the main space victim. Either the
+// victim or the candidate is evicted otherwise, returning true.
+func (c *tinyLFU[K, V]) admit(key K, value V) bool {
+	if c.mainLen() < c.size-c.windowSize {
+		c.probation.add(key, value)
+		return false
+	}
+	victims := c.probation
+	if victims.len() == 0 {
+		victims = c.protected
+	}
+	victim := victims.evictList.back()
+	if victim != nil && c.sketch.estimate(c.hash(key)) > c.sketch.estimate(c.hash(victim.key)) {
+		victims.removeElement(victim)
+		c.probation.add(key, value)
+		return true
+	}
+	c.evicted.add(key, value)
+	return true
+}
+
+// promote adds a probation entry used again to protected, demoting the
+// oldest protected entries back to probation when it is over its size.
+func (c *tinyLFU[K, V]) promote(key K, value V) {
+	c.protected.add(key, value)
+	for c.protected.len() > c.protectedSize {
+		k, v, ok := c.protected.detachOldest()
+		if !ok {
+			break
+		}
+		c.probation.add(k, v)
+	}
+}
+
+// Get looks up a key's value from the cache.
+func (c *tinyLFU[K, V]) Get(key K) (value V, ok bool) {
+	h := c.hash(key)
+	c.Lock()
+	defer c.Unlock()
+	c.sketch.increment(h)
+	if value, ok = c.window.get(key); ok {
+		return value, true
+	}
+	if value, ok = c.protected.get(key); ok {
+		return value, true
+	}
+	if value, ok = c.probation.detach(key); ok {
+		c.promote(key, value)
+		return value, true
+	}
+	return
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness or deleting it for being stale.
+func (c *tinyLFU[K, V]) Contains(key K) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.contains(key)
+}
+
+func (c *tinyLFU[K, V]) contains(key K) bool {
+	return c.window.contains(key) || c.probation.contains(key) || c.protected.contains(key)
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *tinyLFU[K, V]) Peek(key K) (value V, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.peek(key)
+}
+
+func (c *tinyLFU[K, V]) peek(key K) (value V, ok bool) {
+	if value, ok = c.window.peek(key); ok {
+		return value, true
+	}
+	if value, ok = c.probation.peek(key); ok {
+		return value, true
+	}
+	return c.protected.peek(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *tinyLFU[K, V]) Remove(key K) (present bool) {
+	c.Lock()
+	present = c.window.remove(key) || c.probation.remove(key) || c.protected.remove(key)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return present
+}
+
+// Keys returns a slice of the keys in the cache, the probation, protected
+// and window entries in turn, each from oldest to newest.
+func (c *tinyLFU[K, V]) Keys() []K {
+	c.RLock()
+	defer c.RUnlock()
+	keys := c.probation.keys()
+	keys = append(keys, c.protected.keys()...)
+	return append(keys, c.window.keys()...)
+}
+
+// Len returns the number of items in the cache.
+func (c *tinyLFU[K, V]) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.window.len() + c.mainLen()
+}
+
+// Purge is used to completely clear the cache.
+func (c *tinyLFU[K, V]) Purge() {
+	c.Lock()
+	c.window.purge()
+	c.probation.purge()
+	c.protected.purge()
+	c.sketch.clear()
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+}
+
+// Resize changes the cache size, returning the number evicted.
+func (c *tinyLFU[K, V]) Resize(size int) (evicted int) {
+	if size <= 0 {
+		return 0
+	}
+	c.Lock()
+	c.setSize(size)
+	c.sketch.resize(size)
+	evicted = c.evictWindow()
+	for c.mainLen() > size-c.windowSize {
+		if _, _, ok := c.probation.removeOldest(); !ok {
+			c.protected.removeOldest()
+		}
+		evicted++
+	}
+	for c.protected.len() > c.protectedSize {
+		k, v, _ := c.protected.detachOldest()
+		c.probation.add(k, v)
+	}
+	c.window.resize(size)
+	c.probation.resize(size)
+	c.protected.resize(size)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// ContainsOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *tinyLFU[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
+	h := c.hash(key)
+	c.Lock()
+	if c.contains(key) {
+		c.Unlock()
+		return true, false
+	}
+	evicted = c.add(key, h, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return false, evicted
+}
+
+// PeekOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *tinyLFU[K, V]) PeekOrAdd(key K, value V) (previous V, ok, evicted bool) {
+	h := c.hash(key)
+	c.Lock()
+	if previous, ok = c.peek(key); ok {
+		c.Unlock()
+		return previous, true, false
+	}
+	evicted = c.add(key, h, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
+
+// formatHash hashes the formatted key, for keys basicHash cannot hash. It
+// only feeds the frequency sketch, where equal keys hashing apart, like
+// -0 and +0 in a struct, merely skew the estimate.
+func formatHash[K comparable](key K) uint64 {
+	return maphash.String(hashSeed, fmt.Sprintf("%#v", key))
+}
@@ -0,0 +1,249 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// default2QRecentRatio is the share of the cache given to recently
+	// added entries
+	default2QRecentRatio = 0.25
+	// default2QGhostEntries is the share of the cache size kept as ghosts
+	// of entries evicted from the recent list
+	default2QGhostEntries = 0.50
+)
+
+var (
+	_ Cache[int, int] = &twoQueue[int, int]{}
+)
+
+// twoQueue is a thread-safe fixed size 2Q cache. New entries go to a small
+// recent list and only move to the frequent list when used again, so a
+// scan of one-time keys cannot flush the frequently used ones.
+type twoQueue[K comparable, V any] struct {
+	size       int
+	recentSize int
+
+	recent      *cLRU[K, V]        // A1in, used once
+	frequent    *cLRU[K, V]        // Am, used at least twice
+	recentEvict *cLRU[K, struct{}] // A1out, ghosts evicted from recent
+
+	evicted *evictBuffer[K, V]
+	sync.RWMutex
+}
+
+// New2Q constructs a fixed size 2Q cache with the given eviction
+// callback.
+func New2Q[K comparable, V any](size int, onEvicted func(key K, value V)) (Cache[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	c := &twoQueue[K, V]{
+		size:       size,
+		recentSize: twoQueueRecentSize(size),
+		evicted:    newEvictBuffer(onEvicted),
+	}
+	// recent and frequent never evict on their own, ensureSpace decides
+	// between them
+	c.recent, _ = NewcLRU(size, c.evicted.add)
+	c.frequent, _ = NewcLRU(size, c.evicted.add)
+	c.recentEvict, _ = NewcLRU[K, struct{}](twoQueueGhostSize(size), nil)
+	return c, nil
+}
+
+// twoQueueRecentSize returns the recent list size for a cache size.
+func twoQueueRecentSize(size int) int {
+	return int(float64(size) * default2QRecentRatio)
+}
+
+// twoQueueGhostSize returns the ghost list size for a cache size.
+func twoQueueGhostSize(size int) int {
+	if n := int(float64(size) * default2QGhostEntries); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Add adds a value to the cache. Returns true if an eviction occurred.
+func (c *twoQueue[K, V]) Add(key K, value V) (evicted bool) {
+	c.Lock()
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// add adds a value, promoting keys seen before to the frequent list.
+func (c *twoQueue[K, V]) add(key K, value V) (evicted bool) {
+	if c.frequent.contains(key) {
+		c.frequent.add(key, value)
+		return false
+	}
+	// a second use promotes to frequent
+	if _, ok := c.recent.detach(key); ok {
+		c.frequent.add(key, value)
+		return false
+	}
+	// a recently evicted key goes straight to frequent
+	if c.recentEvict.contains(key) {
+		evicted = c.ensureSpace(true)
+		c.recentEvict.remove(key)
+		c.frequent.add(key, value)
+		return evicted
+	}
+	evicted = c.ensureSpace(false)
+	c.recent.add(key, value)
+	return evicted
+}
+
+// ensureSpace evicts an entry when the cache is full, preferring the
+// recent list while it is over its share. Returns true if an eviction
+// occurred.
+func (c *twoQueue[K, V]) ensureSpace(recentEvict bool) bool {
+	recentLen, freqLen := c.recent.len(), c.frequent.len()
+	if recentLen+freqLen < c.size {
+		return false
+	}
+	if recentLen > 0 && (recentLen > c.recentSize || (recentLen == c.recentSize && !recentEvict)) {
+		if k, _, ok := c.recent.removeOldest(); ok {
+			c.recentEvict.add(k, struct{}{})
+			return true
+		}
+	}
+	if _, _, ok := c.frequent.removeOldest(); ok {
+		return true
+	}
+	if k, _, ok := c.recent.removeOldest(); ok {
+		c.recentEvict.add(k, struct{}{})
+		return true
+	}
+	return false
+}
+
+// Get looks up a key's value from the cache.
+func (c *twoQueue[K, V]) Get(key K) (value V, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	if value, ok = c.frequent.get(key); ok {
+		return value, true
+	}
+	// a second use promotes to frequent
+	if value, ok = c.recent.detach(key); ok {
+		c.frequent.add(key, value)
+		return value, true
+	}
+	return
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness or deleting it for being stale.
+func (c *twoQueue[K, V]) Contains(key K) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.frequent.contains(key) || c.recent.contains(key)
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *twoQueue[K, V]) Peek(key K) (value V, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	if value, ok = c.frequent.peek(key); ok {
+		return value, true
+	}
+	return c.recent.peek(key)
+}
+
+// Remove removes the provided key from the cache.
+func (c *twoQueue[K, V]) Remove(key K) (present bool) {
+	c.Lock()
+	present = c.frequent.remove(key) || c.recent.remove(key)
+	c.recentEvict.remove(key)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return present
+}
+
+// Keys returns a slice of the keys in the cache, the frequently used ones
+// first and then the recently used ones, each from oldest to newest.
+func (c *twoQueue[K, V]) Keys() []K {
+	c.RLock()
+	defer c.RUnlock()
+	return append(c.frequent.keys(), c.recent.keys()...)
+}
+
+// Len returns the number of items in the cache.
+func (c *twoQueue[K, V]) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.recent.len() + c.frequent.len()
+}
+
+// Purge is used to completely clear the cache.
+func (c *twoQueue[K, V]) Purge() {
+	c.Lock()
+	c.recent.purge()
+	c.frequent.purge()
+	c.recentEvict.purge()
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+}
+
+// Resize changes the cache size, returning the number evicted.
+func (c *twoQueue[K, V]) Resize(size int) (evicted int) {
+	if size <= 0 {
+		return 0
+	}
+	c.Lock()
+	c.size = size
+	c.recentSize = twoQueueRecentSize(size)
+	for c.recent.len()+c.frequent.len() > size && c.ensureSpace(false) {
+		evicted++
+	}
+	c.recent.resize(size)
+	c.frequent.resize(size)
+	c.recentEvict.resize(twoQueueGhostSize(size))
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return evicted
+}
+
+// ContainsOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *twoQueue[K, V]) ContainsOrAdd(key K, value V) (ok, evicted bool) {
+	c.Lock()
+	if c.frequent.contains(key) || c.recent.contains(key) {
+		c.Unlock()
+		return true, false
+	}
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return false, evicted
+}
+
+// PeekOrAdd checks if a key is in the cache without updating the
+// recent-ness or deleting it for being stale, and if not, adds the value.
+// Returns whether found and whether an eviction occurred.
+func (c *twoQueue[K, V]) PeekOrAdd(key K, value V) (previous V, ok, evicted bool) {
+	c.Lock()
+	if previous, ok = c.frequent.peek(key); !ok {
+		previous, ok = c.recent.peek(key)
+	}
+	if ok {
+		c.Unlock()
+		return previous, true, false
+	}
+	evicted = c.add(key, value)
+	ks, vs := c.evicted.take()
+	c.Unlock()
+	c.evicted.call(ks, vs)
+	return
+}
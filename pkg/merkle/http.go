@@ -0,0 +1,245 @@
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// SyncPath prefixes the sync API of a Handler
+	SyncPath = "/_merkle/"
+	// maxSyncBody bounds the body of a sync request
+	maxSyncBody = 64 << 20
+)
+
+var (
+	_ Remote = &httpRemote{}
+
+	errSyncBody  = fmt.Errorf("merkle: malformed sync body")
+	errSyncLarge = fmt.Errorf("merkle: sync reply over %v bytes", maxSyncBody)
+)
+
+type (
+	// httpRemote is a Remote served by a Handler
+	httpRemote struct {
+		url    string
+		client *http.Client
+	}
+	handler struct {
+		t Tree
+	}
+)
+
+// NewHTTPRemote returns a Remote for the Handler at serverURL. The client
+// can come from mtls.NewHTTPClient to sync over mutual TLS.
+func NewHTTPRemote(serverURL string, client *http.Client) Remote {
+	return &httpRemote{
+		url:    strings.TrimSuffix(serverURL, "/") + SyncPath,
+		client: client,
+	}
+}
+
+// Handler serves the sync API of t under SyncPath, for NewHTTPRemote:
+// POST root takes nothing, POST summaries and keys take ranges, POST add
+// takes keys. Serve it with
+// mtls.NewHTTPServer to only sync with peers sharing a secret.
+func Handler(t Tree) http.Handler {
+	return &handler{t: t}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.URL.Path, SyncPath)
+	if op == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSyncBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	remote := NewTreeRemote(h.t)
+	var out []byte
+	switch op {
+	case "root":
+		root, _ := remote.Root(r.Context())
+		out = root[:]
+	case "summaries":
+		ranges, err := readRanges(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ss, _ := remote.Summaries(r.Context(), ranges)
+		out = appendSummaries(nil, ss)
+	case "keys":
+		ranges, err := readRanges(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ks, _ := remote.Keys(r.Context(), ranges)
+		out = appendKeys(nil, ks)
+	case "add":
+		ks, err := readKeys(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remote.Add(r.Context(), ks)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(out)
+}
+
+func (r *httpRemote) Root(ctx context.Context) (Key, error) {
+	b, err := r.post(ctx, "root", nil)
+	if err != nil {
+		return Key{}, err
+	}
+	ks, err := readKeys(b)
+	if err != nil || len(ks) != 1 {
+		return Key{}, errSyncBody
+	}
+	return ks[0], nil
+}
+
+func (r *httpRemote) Summaries(ctx context.Context, ranges []Range) ([]Summary, error) {
+	b, err := r.post(ctx, "summaries", appendRanges(nil, ranges))
+	if err != nil {
+		return nil, err
+	}
+	return readSummaries(b)
+}
+
+func (r *httpRemote) Keys(ctx context.Context, ranges []Range) ([]Key, error) {
+	b, err := r.post(ctx, "keys", appendRanges(nil, ranges))
+	if err != nil {
+		return nil, err
+	}
+	return readKeys(b)
+}
+
+func (r *httpRemote) Add(ctx context.Context, keys []Key) error {
+	_, err := r.post(ctx, "add", appendKeys(nil, keys))
+	return err
+}
+
+// post sends body to op, returning the response body.
+func (r *httpRemote) post(ctx context.Context, op string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("merkle: %s: %s: %s", r.url+op, resp.Status, strings.TrimSpace(string(b)))
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSyncBody+1))
+	if err == nil && len(b) > maxSyncBody {
+		return nil, errSyncLarge
+	}
+	return b, err
+}
+
+// appendRanges writes each range as its bits, a uvarint, and the bytes
+// of its prefix holding them.
+func appendRanges(b []byte, ranges []Range) []byte {
+	for _, r := range ranges {
+		bits := r.Bits
+		switch {
+		case bits < 0:
+			bits = 0
+		case bits > KeySize*8:
+			bits = KeySize * 8
+		}
+		lo := r.lo()
+		b = binary.AppendUvarint(b, uint64(bits))
+		b = append(b, lo[:(bits+7)/8]...)
+	}
+	return b
+}
+
+// readRanges reads ranges written by appendRanges.
+func readRanges(b []byte) ([]Range, error) {
+	out := []Range{}
+	for r := bytes.NewReader(b); r.Len() > 0; {
+		bits, err := binary.ReadUvarint(r)
+		if err != nil || bits > KeySize*8 {
+			return nil, errSyncBody
+		}
+		rg := Range{Bits: int(bits)}
+		if _, err := io.ReadFull(r, rg.Prefix[:(bits+7)/8]); err != nil {
+			return nil, errSyncBody
+		}
+		out = append(out, rg)
+	}
+	return out, nil
+}
+
+// appendSummaries writes each summary as its count, a uvarint, and hash.
+func appendSummaries(b []byte, ss []Summary) []byte {
+	for _, s := range ss {
+		b = binary.AppendUvarint(b, uint64(s.Count))
+		b = append(b, s.Hash[:]...)
+	}
+	return b
+}
+
+// readSummaries reads summaries written by appendSummaries.
+func readSummaries(b []byte) ([]Summary, error) {
+	out := []Summary{}
+	for r := bytes.NewReader(b); r.Len() > 0; {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(^uint(0)>>1) {
+			return nil, errSyncBody
+		}
+		s := Summary{Count: int(n)}
+		if _, err := io.ReadFull(r, s.Hash[:]); err != nil {
+			return nil, errSyncBody
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// appendKeys writes the keys one after the other.
+func appendKeys(b []byte, ks []Key) []byte {
+	for _, k := range ks {
+		b = append(b, k[:]...)
+	}
+	return b
+}
+
+// readKeys reads keys written by appendKeys.
+func readKeys(b []byte) ([]Key, error) {
+	if len(b)%KeySize != 0 {
+		return nil, errSyncBody
+	}
+	out := make([]Key, len(b)/KeySize)
+	for i := range out {
+		copy(out[i][:], b[i*KeySize:])
+	}
+	return out, nil
+}
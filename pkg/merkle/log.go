@@ -0,0 +1,247 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+const (
+	// leafPrefix and nodePrefix separate leaf and node hashes of a Log and
+	// of a V2 Tree
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+var (
+	_ Log = &appendLog{}
+
+	errLogSize = fmt.Errorf("merkle: tree size out of range")
+)
+
+type (
+	// Log is an append-only merkle tree as in RFC 6962, it keeps the
+	// order entries are appended in so it can prove that a newer root
+	// extends an older one.
+	Log interface {
+		// Append adds an entry, returning its index
+		Append(data []byte) int
+		Len() int
+		// Root returns the root of the whole log
+		Root() Key
+		// RootAt returns the root of the first size entries
+		RootAt(size int) (Key, error)
+		// Leaf returns the leaf hash of an entry
+		Leaf(index int) (Key, error)
+		// InclusionProof proves that an entry is in the tree of size
+		InclusionProof(index, size int) (*Proof, error)
+		// ConsistencyProof proves that the tree of newSize extends the
+		// tree of oldSize
+		ConsistencyProof(oldSize, newSize int) ([]Key, error)
+	}
+	appendLog struct {
+		leaves []Key
+		m      sync.RWMutex
+	}
+)
+
+// NewLog returns an empty append-only log.
+func NewLog() Log {
+	return &appendLog{}
+}
+
+// LeafHash returns the hash of a log entry.
+func LeafHash(data []byte) Key {
+	return sha256.Sum256(append([]byte{leafPrefix}, data...))
+}
+
+// nodeHash returns the hash of two children in a Log or a V2 Tree.
+func nodeHash(l, r Key) Key {
+	b := make([]byte, 0, 1+2*KeySize)
+	b = append(b, nodePrefix)
+	b = append(b, l[:]...)
+	return sha256.Sum256(append(b, r[:]...))
+}
+
+// split returns the largest power of two smaller than n.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func (l *appendLog) Append(data []byte) int {
+	leaf := LeafHash(data)
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.leaves = append(l.leaves, leaf)
+	return len(l.leaves) - 1
+}
+
+func (l *appendLog) Len() int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return len(l.leaves)
+}
+
+func (l *appendLog) Root() Key {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.hash(0, len(l.leaves))
+}
+
+func (l *appendLog) RootAt(size int) (Key, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	if size < 0 || size > len(l.leaves) {
+		return Key{}, errLogSize
+	}
+	return l.hash(0, size), nil
+}
+
+func (l *appendLog) Leaf(index int) (Key, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	if index < 0 || index >= len(l.leaves) {
+		return Key{}, errLogSize
+	}
+	return l.leaves[index], nil
+}
+
+// hash returns the root of leaves lo to hi, the hash of nothing if empty.
+func (l *appendLog) hash(lo, hi int) Key {
+	switch n := hi - lo; n {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return l.leaves[lo]
+	default:
+		k := split(n)
+		return nodeHash(l.hash(lo, lo+k), l.hash(lo+k, hi))
+	}
+}
+
+func (l *appendLog) InclusionProof(index, size int) (*Proof, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	if size > len(l.leaves) || index < 0 || index >= size {
+		return nil, errLogSize
+	}
+	return &Proof{Index: index, Size: size, Path: l.path(index, 0, size)}, nil
+}
+
+// path returns the siblings of leaf m of leaves lo to hi, bottom up.
+func (l *appendLog) path(m, lo, hi int) []Key {
+	n := hi - lo
+	if n <= 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(l.path(m, lo, lo+k), l.hash(lo+k, hi))
+	}
+	return append(l.path(m-k, lo+k, hi), l.hash(lo, lo+k))
+}
+
+// ConsistencyProof is empty when either size is zero or both are equal.
+func (l *appendLog) ConsistencyProof(oldSize, newSize int) ([]Key, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	if oldSize < 0 || oldSize > newSize || newSize > len(l.leaves) {
+		return nil, errLogSize
+	}
+	if oldSize == 0 {
+		return nil, nil
+	}
+	return l.subproof(oldSize, 0, newSize, true), nil
+}
+
+// subproof is SUBPROOF of RFC 6962 for the first m of leaves lo to hi,
+// complete tells whether the old tree is a whole subtree of the new one.
+func (l *appendLog) subproof(m, lo, hi int, complete bool) []Key {
+	n := hi - lo
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []Key{l.hash(lo, hi)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(l.subproof(m, lo, lo+k, complete), l.hash(lo+k, hi))
+	}
+	return append(l.subproof(m-k, lo+k, hi, false), l.hash(lo, lo+k))
+}
+
+// VerifyInclusion reports whether proof shows that leaf, the LeafHash of
+// an entry, is in the log tree of root.
+func VerifyInclusion(root, leaf Key, proof *Proof) bool {
+	if proof == nil || proof.Index < 0 || proof.Index >= proof.Size {
+		return false
+	}
+	fn, sn := proof.Index, proof.Size-1
+	r := leaf
+	for _, p := range proof.Path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r == root
+}
+
+// VerifyConsistency reports whether proof shows that the log tree of
+// newRoot, with newSize entries, extends the tree of oldRoot with oldSize.
+func VerifyConsistency(oldSize int, oldRoot Key, newSize int, newRoot Key, proof []Key) bool {
+	switch {
+	case oldSize < 0 || oldSize > newSize:
+		return false
+	case oldSize == 0:
+		return len(proof) == 0
+	case oldSize == newSize:
+		return len(proof) == 0 && oldRoot == newRoot
+	}
+	if oldSize&(oldSize-1) == 0 {
+		// the old tree is a whole subtree, its root starts the path
+		proof = append([]Key{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && fr == oldRoot && sr == newRoot
+}
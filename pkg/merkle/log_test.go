@@ -0,0 +1,143 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+var (
+	// the leaves and roots of the RFC 6962 test vectors of
+	// certificate-transparency
+	testLogEntries = []string{
+		"",
+		"\x00",
+		"\x10",
+		"\x20\x21",
+		"\x30\x31",
+		"\x40\x41\x42\x43",
+		"\x50\x51\x52\x53\x54\x55\x56\x57",
+		"\x60\x61\x62\x63\x64\x65\x66\x67\x68\x69\x6a\x6b\x6c\x6d\x6e\x6f",
+	}
+	testLogRoots = map[int]string{
+		0: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		1: "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		8: "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+)
+
+func TestLogRoots(t *testing.T) {
+	l := NewLog()
+	for _, e := range testLogEntries {
+		l.Append([]byte(e))
+	}
+	for size, want := range testLogRoots {
+		r, err := l.RootAt(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(r[:]) != want {
+			t.Errorf("size %v: expecting root %v, is %x", size, want, r)
+		}
+	}
+	if _, err := l.RootAt(len(testLogEntries) + 1); err == nil {
+		t.Errorf("expecting size error")
+	}
+}
+
+func TestLogInclusion(t *testing.T) {
+	l := NewLog()
+	for n := 1; n <= 40; n++ {
+		if i := l.Append([]byte(fmt.Sprintf("e%d", n))); i != n-1 {
+			t.Fatalf("bad index %v", i)
+		}
+		root := l.Root()
+		for i := 0; i < n; i++ {
+			p, err := l.InclusionProof(i, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, _ := l.Leaf(i)
+			if !VerifyInclusion(root, leaf, p) {
+				t.Fatalf("%v entries: proof of %v does not verify", n, i)
+			}
+			if leaf != LeafHash([]byte(fmt.Sprintf("e%d", i+1))) {
+				t.Fatalf("bad leaf %v", i)
+			}
+			// the path has the same shape as for the sorted tree
+			if len(p.Path) != pathLen(i, n) {
+				t.Fatalf("%v entries: bad path length for %v", n, i)
+			}
+			if VerifyInclusion(root, LeafHash([]byte("other")), p) {
+				t.Fatalf("%v entries: proof verifies another entry", n)
+			}
+			if n > 1 {
+				moved := *p
+				moved.Index = (i + 1) % n
+				if VerifyInclusion(root, leaf, &moved) {
+					t.Fatalf("%v entries: proof verifies at another index", n)
+				}
+			}
+		}
+	}
+	if _, err := l.InclusionProof(5, 5); err == nil {
+		t.Errorf("expecting index error")
+	}
+}
+
+func TestLogConsistency(t *testing.T) {
+	l := NewLog()
+	roots := []Key{l.Root()}
+	for n := 1; n <= 40; n++ {
+		l.Append([]byte(fmt.Sprintf("e%d", n)))
+		roots = append(roots, l.Root())
+	}
+	for n := 0; n <= 40; n++ {
+		for m := 0; m <= n; m++ {
+			p, err := l.ConsistencyProof(m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyConsistency(m, roots[m], n, roots[n], p) {
+				t.Fatalf("%v to %v: proof does not verify", m, n)
+			}
+			if m == 0 || m == n {
+				continue
+			}
+			if VerifyConsistency(m, roots[m-1], n, roots[n], p) {
+				t.Fatalf("%v to %v: proof verifies another old root", m, n)
+			}
+			if VerifyConsistency(m, roots[m], n, roots[n-1], p) {
+				t.Fatalf("%v to %v: proof verifies another new root", m, n)
+			}
+			if n < 40 && VerifyConsistency(m, roots[m], n+1, roots[n+1], p) {
+				t.Fatalf("%v to %v: proof verifies another size", m, n)
+			}
+			for i := range p {
+				bad := append([]Key{}, p...)
+				bad[i][0] ^= 1
+				if VerifyConsistency(m, roots[m], n, roots[n], bad) {
+					t.Fatalf("%v to %v: tampered proof verifies", m, n)
+				}
+			}
+		}
+	}
+	// a rewritten history is caught
+	forked := NewLog()
+	for n := 1; n <= 10; n++ {
+		forked.Append([]byte(fmt.Sprintf("f%d", n)))
+	}
+	for n := 11; n <= 20; n++ {
+		forked.Append([]byte(fmt.Sprintf("e%d", n)))
+	}
+	p, _ := forked.ConsistencyProof(10, 20)
+	if VerifyConsistency(10, roots[10], 20, forked.Root(), p) {
+		t.Errorf("forked log should not be consistent")
+	}
+	if _, err := l.ConsistencyProof(5, 41); err == nil {
+		t.Errorf("expecting size error")
+	}
+	if _, err := l.ConsistencyProof(6, 5); err == nil {
+		t.Errorf("expecting size error")
+	}
+}
@@ -0,0 +1,160 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = &Proof{}
+	_ encoding.BinaryUnmarshaler = &Proof{}
+	_ encoding.TextMarshaler     = &Proof{}
+	_ encoding.TextUnmarshaler   = &Proof{}
+
+	errProof = fmt.Errorf("merkle: malformed proof")
+)
+
+type (
+	// Proof is the path from a key to the root, the siblings of the key
+	// and of its parents from the bottom up. Index and Size place the key
+	// among the sorted keys, which tells on which side each sibling goes
+	// and where an odd node was hashed alone. A V3 proof places the key
+	// in a full tree of Size 1<<len(Path) keys instead, the bits of Index
+	// telling its side at each level from the bottom up.
+	Proof struct {
+		Index int
+		Size  int
+		Path  []Key
+	}
+)
+
+// Proof collects the siblings of k on the way up to the root.
+func (t *tree) Proof(k Key) (*Proof, bool) {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	i := t.search(k)
+	if i == len(t.keys) || *t.keys[i] != k {
+		return nil, false
+	}
+	proof := &Proof{Index: i, Size: len(t.keys)}
+	for _, row := range t.levels[:len(t.levels)-1] {
+		if s := i ^ 1; s < len(row) {
+			proof.Path = append(proof.Path, row[s])
+		}
+		i /= 2
+	}
+	return proof, true
+}
+
+// pathLen returns the number of siblings of a proof for index of size.
+func pathLen(index, size int) int {
+	n := 0
+	for ; size > 1; size = (size + 1) / 2 {
+		if index^1 < size {
+			n++
+		}
+		index /= 2
+	}
+	return n
+}
+
+// VerifyProof reports whether proof shows that key is in the V1 tree of
+// root.
+func VerifyProof(root, key Key, proof *Proof) bool {
+	return V1.VerifyProof(root, key, proof)
+}
+
+// VerifyProof reports whether proof shows that key is in the tree of root
+// hashed with v. The verifier picks the version, not the proof.
+func (v Version) VerifyProof(root, key Key, proof *Proof) bool {
+	if proof == nil || proof.Index < 0 || proof.Index >= proof.Size ||
+		len(proof.Path) != pathLen(proof.Index, proof.Size) {
+		return false
+	}
+	h, path := v.leaf(key), proof.Path
+	for i, n := proof.Index, proof.Size; n > 1; i, n = i/2, (n+1)/2 {
+		switch {
+		case i%2 == 1:
+			h = v.node(path[0], h)
+			path = path[1:]
+		case i+1 == n:
+			h = v.odd(h)
+		default:
+			h = v.node(h, path[0])
+			path = path[1:]
+		}
+	}
+	return h == root
+}
+
+// MarshalBinary writes the index and size as uvarints and then the path.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 2*binary.MaxVarintLen64+len(p.Path)*KeySize)
+	b = binary.AppendUvarint(b, uint64(p.Index))
+	b = binary.AppendUvarint(b, uint64(p.Size))
+	for _, k := range p.Path {
+		b = append(b, k[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary reads a proof written by MarshalBinary.
+func (p *Proof) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+	index, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errProof
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || index >= size || size > uint64(^uint(0)>>1) {
+		return errProof
+	}
+	n := pathLen(int(index), int(size))
+	if r.Len() != n*KeySize {
+		return errProof
+	}
+	path := make([]Key, n)
+	for i := range path {
+		r.Read(path[i][:])
+	}
+	p.Index, p.Size, p.Path = int(index), int(size), path
+	return nil
+}
+
+// MarshalText writes the binary form in base64, like Key.String.
+func (p *Proof) MarshalText() ([]byte, error) {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	t := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(t, b)
+	return t, nil
+}
+
+// UnmarshalText reads a proof written by MarshalText.
+func (p *Proof) UnmarshalText(t []byte) error {
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(t)))
+	n, err := base64.StdEncoding.Decode(b, t)
+	if err != nil {
+		return errProof
+	}
+	return p.UnmarshalBinary(b[:n])
+}
+
+func (p *Proof) String() string {
+	t, _ := p.MarshalText()
+	return string(t)
+}
+
+// ParseProof reads a proof from its String form.
+func ParseProof(s string) (*Proof, error) {
+	p := &Proof{}
+	if err := p.UnmarshalText([]byte(s)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,106 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestProof(t *testing.T) {
+	m := New()
+	other := Key(sha256.Sum256([]byte("other")))
+	if _, ok := m.Proof(other); ok {
+		t.Errorf("empty tree should have no proof")
+	}
+	for n := 1; n <= 40; n++ {
+		m.Add(sha256.Sum256([]byte(fmt.Sprintf("k%d", n))))
+		root := m.Root()
+		for _, k := range m.Keys() {
+			p, ok := m.Proof(*k)
+			if !ok {
+				t.Fatalf("%v keys: no proof for %v", n, k)
+			}
+			if !VerifyProof(root, *k, p) {
+				t.Fatalf("%v keys: proof %v of %v does not verify", n, p.Index, k)
+			}
+			if VerifyProof(root, other, p) {
+				t.Fatalf("%v keys: proof verifies another key", n)
+			}
+			if len(p.Path) > 0 {
+				bad := *p
+				bad.Path = append([]Key{}, p.Path...)
+				bad.Path[0][0] ^= 1
+				if VerifyProof(root, *k, &bad) {
+					t.Fatalf("%v keys: tampered proof verifies", n)
+				}
+			}
+			if n > 1 {
+				moved := *p
+				moved.Index = (p.Index + 1) % n
+				if VerifyProof(root, *k, &moved) {
+					t.Fatalf("%v keys: proof verifies at another index", n)
+				}
+			}
+		}
+	}
+	if _, ok := m.Proof(other); ok {
+		t.Errorf("missing key should have no proof")
+	}
+	if VerifyProof(m.Root(), *m.Keys()[0], nil) {
+		t.Errorf("nil proof should not verify")
+	}
+}
+
+func TestProofEncoding(t *testing.T) {
+	m := New()
+	for _, k := range testKeys {
+		m.Add(k)
+	}
+	root := m.Root()
+	p, _ := m.Proof(testKeys[4])
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2+len(p.Path)*KeySize {
+		t.Errorf("binary proof should be compact, is %v bytes", len(b))
+	}
+	p2 := &Proof{}
+	if err := p2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyProof(root, testKeys[4], p2) {
+		t.Errorf("decoded proof does not verify")
+	}
+	for _, bad := range [][]byte{nil, b[:1], b[:len(b)-1], append(b, 0), {5, 5}} {
+		if err := (&Proof{}).UnmarshalBinary(bad); err == nil {
+			t.Errorf("should not decode %v", bad)
+		}
+	}
+
+	p3, err := ParseProof(p.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyProof(root, testKeys[4], p3) {
+		t.Errorf("parsed proof does not verify")
+	}
+	if _, err := ParseProof("not base64!"); err == nil {
+		t.Errorf("should not parse bad base64")
+	}
+	j, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j) != `"`+p.String()+`"` {
+		t.Errorf("json should be the base64 string, is %s", j)
+	}
+	p4 := &Proof{}
+	if err := json.Unmarshal(j, p4); err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyProof(root, testKeys[4], p4) {
+		t.Errorf("json proof does not verify")
+	}
+}
@@ -0,0 +1,307 @@
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// syncFanout is the number of key bits a range is split by per round
+	syncFanout = 4
+	// syncLeaf is the most keys, counting both sides, of a range that is
+	// swapped whole instead of split
+	syncLeaf = 32
+	// syncBatch is the most ranges or keys sent or fetched in one call to
+	// a Remote, which keeps requests well under maxSyncBody
+	syncBatch = 1 << 16
+)
+
+var (
+	_ Remote = &treeRemote{}
+	_ ranger = &tree{}
+
+	errSyncReply = fmt.Errorf("merkle: remote answered for other ranges")
+)
+
+type (
+	// Range is the keys whose first Bits bits are those of Prefix, the
+	// zero Range is every key.
+	Range struct {
+		Prefix Key
+		Bits   int
+	}
+	// Summary is the number of keys of a tree in a range and the sum of
+	// their LeafHash, as 256 bit big-endian numbers, which a tree keeps
+	// cached for any range. Unlike a subtree hash, a sum is not collision
+	// resistant: whoever picks the keys can find other keys with the same
+	// sum, generalized birthday attacks make it cheap, and Sync would take
+	// the ranges for equal.
+	Summary struct {
+		Count int
+		Hash  Key
+	}
+	// Remote is the other side of a Sync.
+	Remote interface {
+		// Root returns the root of the tree
+		Root(ctx context.Context) (Key, error)
+		// Summaries returns a summary of each range
+		Summaries(ctx context.Context, ranges []Range) ([]Summary, error)
+		// Keys returns the keys in the ranges
+		Keys(ctx context.Context, ranges []Range) ([]Key, error)
+		// Add adds keys
+		Add(ctx context.Context, keys []Key) error
+	}
+	// SyncResult counts what a Sync did.
+	SyncResult struct {
+		// Pulled keys were added to the local tree
+		Pulled int
+		// Pushed keys were added to the remote
+		Pushed int
+		// Rounds is the number of times summaries were compared
+		Rounds int
+	}
+	// treeRemote is a Remote over a tree in the same process
+	treeRemote struct {
+		t Tree
+	}
+	// ranger is what Sync reads of a tree, beyond a Tree
+	ranger interface {
+		summary(Range) Summary
+		keysIn(Range) []Key
+	}
+)
+
+// Sync makes local and remote hold the union of their keys. Unless their
+// roots match, both compare summaries of ranges of keys top-down,
+// splitting the ranges that differ, and only swap the keys of small
+// ranges that differ, so replicas that mostly agree exchange little more
+// than the keys they miss. Ranges and keys go to and from the remote in
+// batches of syncBatch, so trees of any size fit the requests a Handler
+// takes. A key removed on one side only comes back from the other.
+//
+// A Summary can be forged with chosen keys, so the remote must be trusted
+// and authenticated, like a Handler served with mtls.NewHTTPServer, and
+// so must whoever adds keys to either tree.
+func Sync(ctx context.Context, local Tree, remote Remote) (SyncResult, error) {
+	res := SyncResult{}
+	root, err := remote.Root(ctx)
+	if err != nil {
+		return res, err
+	}
+	if root == local.Root() {
+		return res, nil
+	}
+	lr := rangerOf(local)
+	for ranges := []Range{{}}; len(ranges) > 0; {
+		res.Rounds++
+		theirs, err := summaries(ctx, remote, ranges)
+		if err != nil {
+			return res, err
+		}
+		// the ranges to fetch, in batches of at most syncBatch keys
+		var next []Range
+		var fetch [][]Range
+		var push []Key
+		n := 0
+		for i, r := range ranges {
+			ours := lr.summary(r)
+			switch {
+			case ours == theirs[i]:
+			case theirs[i].Count == 0:
+				push = append(push, lr.keysIn(r)...)
+			case r.Bits >= KeySize*8 || theirs[i].Count <= syncBatch &&
+				(ours.Count == 0 || ours.Count+theirs[i].Count <= syncLeaf):
+				if n += theirs[i].Count; len(fetch) == 0 || n > syncBatch {
+					fetch = append(fetch, nil)
+					n = theirs[i].Count
+				}
+				fetch[len(fetch)-1] = append(fetch[len(fetch)-1], r)
+			default:
+				next = append(next, r.split()...)
+			}
+		}
+		for _, batch := range fetch {
+			keys, err := remote.Keys(ctx, batch)
+			if err != nil {
+				return res, err
+			}
+			has := make(map[Key]struct{}, len(keys))
+			for _, k := range keys {
+				has[k] = struct{}{}
+			}
+			for _, r := range batch {
+				for _, k := range lr.keysIn(r) {
+					if _, ok := has[k]; ok {
+						delete(has, k)
+					} else {
+						push = append(push, k)
+					}
+				}
+			}
+			for k := range has {
+				local.Add(k)
+				res.Pulled++
+			}
+		}
+		for len(push) > 0 {
+			batch := push
+			if len(batch) > syncBatch {
+				batch = batch[:syncBatch]
+			}
+			if err := remote.Add(ctx, batch); err != nil {
+				return res, err
+			}
+			res.Pushed += len(batch)
+			push = push[len(batch):]
+		}
+		ranges = next
+	}
+	return res, nil
+}
+
+// summaries asks remote for the summaries of ranges, syncBatch at a time.
+func summaries(ctx context.Context, remote Remote, ranges []Range) ([]Summary, error) {
+	out := make([]Summary, 0, len(ranges))
+	for len(ranges) > 0 {
+		batch := ranges
+		if len(batch) > syncBatch {
+			batch = batch[:syncBatch]
+		}
+		ss, err := remote.Summaries(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(ss) != len(batch) {
+			return nil, errSyncReply
+		}
+		out = append(out, ss...)
+		ranges = ranges[len(batch):]
+	}
+	return out, nil
+}
+
+// rangerOf returns t as a ranger, or a copy of its keys if t was not made
+// by New.
+func rangerOf(t Tree) ranger {
+	if r, ok := t.(ranger); ok {
+		return r
+	}
+	ks := t.Keys()
+	sorted := make([]Key, len(ks))
+	for i, k := range ks {
+		sorted[i] = *k
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	c := New()
+	// adding in order appends
+	for _, k := range sorted {
+		c.Add(k)
+	}
+	return c.(ranger)
+}
+
+// addKey returns a+b as 256 bit big-endian numbers, wrapping around.
+func addKey(a, b Key) Key {
+	var c uint64
+	for i := KeySize - 8; i >= 0; i -= 8 {
+		var s uint64
+		s, c = bits.Add64(binary.BigEndian.Uint64(a[i:]), binary.BigEndian.Uint64(b[i:]), c)
+		binary.BigEndian.PutUint64(a[i:], s)
+	}
+	return a
+}
+
+// subKey returns a-b as 256 bit big-endian numbers, wrapping around.
+func subKey(a, b Key) Key {
+	var c uint64
+	for i := KeySize - 8; i >= 0; i -= 8 {
+		var d uint64
+		d, c = bits.Sub64(binary.BigEndian.Uint64(a[i:]), binary.BigEndian.Uint64(b[i:]), c)
+		binary.BigEndian.PutUint64(a[i:], d)
+	}
+	return a
+}
+
+// lo returns the first key of the range, its prefix without the bits
+// after Bits.
+func (r Range) lo() Key {
+	k := Key{}
+	switch {
+	case r.Bits <= 0:
+		return k
+	case r.Bits >= KeySize*8:
+		return r.Prefix
+	}
+	full := r.Bits / 8
+	copy(k[:full], r.Prefix[:full])
+	if rest := r.Bits % 8; rest > 0 {
+		k[full] = r.Prefix[full] & ^byte(0xff>>rest)
+	}
+	return k
+}
+
+// contains reports whether k is in the range.
+func (r Range) contains(k Key) bool {
+	return r.lo() == Range{Prefix: k, Bits: r.Bits}.lo()
+}
+
+// split returns the ranges of the next syncFanout bits.
+func (r Range) split() []Range {
+	b := syncFanout
+	if r.Bits+b > KeySize*8 {
+		b = KeySize*8 - r.Bits
+	}
+	lo := r.lo()
+	out := make([]Range, 1<<b)
+	for j := range out {
+		p := lo
+		for x := 0; x < b; x++ {
+			if j>>(b-1-x)&1 == 1 {
+				bit := r.Bits + x
+				p[bit/8] |= 0x80 >> (bit % 8)
+			}
+		}
+		out[j] = Range{Prefix: p, Bits: r.Bits + b}
+	}
+	return out
+}
+
+// NewTreeRemote returns a Remote over a tree in the same process.
+func NewTreeRemote(t Tree) Remote {
+	return &treeRemote{t: t}
+}
+
+func (r *treeRemote) Root(ctx context.Context) (Key, error) {
+	return r.t.Root(), nil
+}
+
+func (r *treeRemote) Summaries(ctx context.Context, ranges []Range) ([]Summary, error) {
+	t := rangerOf(r.t)
+	out := make([]Summary, len(ranges))
+	for i, rg := range ranges {
+		out[i] = t.summary(rg)
+	}
+	return out, nil
+}
+
+func (r *treeRemote) Keys(ctx context.Context, ranges []Range) ([]Key, error) {
+	t := rangerOf(r.t)
+	out := []Key{}
+	for _, rg := range ranges {
+		out = append(out, t.keysIn(rg)...)
+	}
+	return out, nil
+}
+
+func (r *treeRemote) Add(ctx context.Context, keys []Key) error {
+	for _, k := range keys {
+		r.t.Add(k)
+	}
+	return nil
+}
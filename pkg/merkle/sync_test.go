@@ -0,0 +1,259 @@
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cbluth/go/pkg/mtls"
+)
+
+// countingRemote counts the keys a Remote sends and receives.
+type countingRemote struct {
+	Remote
+	sent, received int
+}
+
+func (c *countingRemote) Keys(ctx context.Context, ranges []Range) ([]Key, error) {
+	ks, err := c.Remote.Keys(ctx, ranges)
+	c.sent += len(ks)
+	return ks, err
+}
+
+func (c *countingRemote) Add(ctx context.Context, keys []Key) error {
+	c.received += len(keys)
+	return c.Remote.Add(ctx, keys)
+}
+
+// randomTrees returns two trees sharing shared keys, each with its own
+// extra keys.
+func randomTrees(rng *rand.Rand, shared, a, b int, opts ...Option) (Tree, Tree) {
+	ta, tb := New(opts...), New(opts...)
+	for i := 0; i < shared; i++ {
+		k := randomKey(rng.Int())
+		ta.Add(k)
+		tb.Add(k)
+	}
+	for i := 0; i < a; i++ {
+		ta.Add(randomKey(rng.Int()))
+	}
+	for i := 0; i < b; i++ {
+		tb.Add(randomKey(rng.Int()))
+	}
+	return ta, tb
+}
+
+func TestSync(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ctx := context.Background()
+	for _, tt := range []struct{ shared, local, remote int }{
+		{0, 0, 0},
+		{0, 10, 0},
+		{0, 0, 100},
+		{100, 0, 0},
+		{2000, 1, 0},
+		{2000, 0, 1},
+		{2000, 50, 70},
+		{5000, 500, 300},
+	} {
+		local, remote := randomTrees(rng, tt.shared, tt.local, tt.remote)
+		c := &countingRemote{Remote: NewTreeRemote(remote)}
+		res, err := Sync(ctx, local, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("%v shared, %v local, %v remote", tt.shared, tt.local, tt.remote)
+		if local.Root() != remote.Root() {
+			t.Fatalf("%v: roots differ after sync", name)
+		}
+		if local.Len() != tt.shared+tt.local+tt.remote {
+			t.Fatalf("%v: bad len %v", name, local.Len())
+		}
+		if res.Pulled != tt.remote || res.Pushed != tt.local || c.received != tt.local {
+			t.Fatalf("%v: bad result %+v", name, res)
+		}
+		// only the keys near a difference are sent
+		if c.sent > tt.remote+syncLeaf*(tt.local+tt.remote) {
+			t.Fatalf("%v: sent %v keys", name, c.sent)
+		}
+		res, err = Sync(ctx, local, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != (SyncResult{}) {
+			t.Fatalf("%v: synced trees should only compare roots: %+v", name, res)
+		}
+	}
+}
+
+// batchRemote records the most keys or ranges in one call.
+type batchRemote struct {
+	Remote
+	calls, most int
+}
+
+func (c *batchRemote) count(n int) {
+	c.calls++
+	if n > c.most {
+		c.most = n
+	}
+}
+
+func (c *batchRemote) Summaries(ctx context.Context, ranges []Range) ([]Summary, error) {
+	c.count(len(ranges))
+	return c.Remote.Summaries(ctx, ranges)
+}
+
+func (c *batchRemote) Keys(ctx context.Context, ranges []Range) ([]Key, error) {
+	ks, err := c.Remote.Keys(ctx, ranges)
+	c.count(len(ks))
+	return ks, err
+}
+
+func (c *batchRemote) Add(ctx context.Context, keys []Key) error {
+	c.count(len(keys))
+	return c.Remote.Add(ctx, keys)
+}
+
+// test that large trees sync in batches, with V3 trees, which add random
+// keys quicker
+func TestSyncBatches(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, tt := range []struct{ local, remote int }{
+		{syncBatch + 1000, 0},
+		{0, syncBatch + 1000},
+	} {
+		local, remote := randomTrees(rng, 0, tt.local, tt.remote, WithVersion(V3))
+		c := &batchRemote{Remote: NewTreeRemote(remote)}
+		res, err := Sync(context.Background(), local, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Pushed != tt.local || res.Pulled != tt.remote || local.Root() != remote.Root() {
+			t.Fatalf("bad sync: %+v", res)
+		}
+		if c.most > syncBatch {
+			t.Fatalf("sent %v in one call", c.most)
+		}
+	}
+}
+
+// test that trees not made by New sync from a copy of their keys
+func TestSyncOtherTree(t *testing.T) {
+	local, remote := randomTrees(rand.New(rand.NewSource(3)), 500, 20, 30)
+	other := struct{ Tree }{local}
+	res, err := Sync(context.Background(), other, NewTreeRemote(struct{ Tree }{remote}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pulled != 30 || res.Pushed != 20 || local.Root() != remote.Root() {
+		t.Fatalf("bad sync: %+v", res)
+	}
+}
+
+func TestRange(t *testing.T) {
+	m := New().(*tree)
+	for i := 0; i < 1000; i++ {
+		m.Add(randomKey(i))
+	}
+	if n := len(m.keysIn(Range{})); n != 1000 {
+		t.Fatalf("zero range should hold every key: %v", n)
+	}
+	// the ranges of a split hold every key once
+	for _, r := range []Range{{}, {Prefix: *m.Keys()[500], Bits: 5}, {Prefix: *m.Keys()[10], Bits: 254}} {
+		all := m.keysIn(r)
+		n := 0
+		for _, sub := range r.split() {
+			ks := m.keysIn(sub)
+			for _, k := range ks {
+				if !r.contains(k) || !sub.contains(k) {
+					t.Fatalf("%v not in range", k)
+				}
+			}
+			n += len(ks)
+		}
+		if n != len(all) {
+			t.Fatalf("split holds %v keys, expecting %v", n, len(all))
+		}
+	}
+	// a summary is the sum of the summaries of a split, the same for
+	// every version
+	for _, v := range []Version{V1, V2, V3} {
+		t2 := New(WithVersion(v))
+		for _, k := range m.Keys() {
+			t2.Add(*k)
+		}
+		m2 := t2.(ranger)
+		for _, r := range []Range{{}, {Prefix: *m.Keys()[500], Bits: 3}, {Prefix: *m.Keys()[7], Bits: 9}, {Prefix: *m.Keys()[10], Bits: 256}} {
+			sum := Summary{}
+			for _, sub := range r.split() {
+				s := m2.summary(sub)
+				sum.Count += s.Count
+				sum.Hash = addKey(sum.Hash, s.Hash)
+			}
+			if s := m2.summary(r); s != sum || s != m.summary(r) || s.Count != len(m.keysIn(r)) {
+				t.Fatalf("v%v: bad summary %v, split sums to %v", v, s, sum)
+			}
+			if ks := m2.keysIn(r); fmt.Sprint(ks) != fmt.Sprint(m.keysIn(r)) {
+				t.Fatalf("v%v: bad keys in range", v)
+			}
+		}
+	}
+	k := *m.Keys()[42]
+	if ks := m.keysIn(Range{Prefix: k, Bits: KeySize * 8}); len(ks) != 1 || ks[0] != k {
+		t.Fatalf("full range should hold its key")
+	}
+}
+
+func TestSyncHTTP(t *testing.T) {
+	secret := []byte("test secret")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := fmt.Sprintf("https://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	local, remote := randomTrees(rand.New(rand.NewSource(2)), 3000, 40, 60)
+	srv, err := mtls.NewHTTPServer(secret, u, &http.Server{Handler: Handler(remote)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(l, "", "")
+	t.Cleanup(func() { srv.Close() })
+
+	ctx := context.Background()
+	c, err := mtls.NewHTTPClient([]byte("another secret"), u, &http.Client{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, local, NewHTTPRemote(u, c)); err == nil {
+		t.Fatalf("should not sync with another secret")
+	}
+	c, err = mtls.NewHTTPClient(secret, u, &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Sync(ctx, local, NewHTTPRemote(u, c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pulled != 60 || res.Pushed != 40 || local.Root() != remote.Root() {
+		t.Fatalf("bad sync: %+v", res)
+	}
+
+	// malformed bodies are refused
+	for op, body := range map[string][]byte{"summaries": {0xff}, "keys": {8}, "add": {1, 2, 3}} {
+		resp, err := c.Post(u+SyncPath+op, "application/octet-stream", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v: bad status %v", op, resp.StatusCode)
+		}
+	}
+}
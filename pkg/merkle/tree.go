@@ -13,6 +13,9 @@ import (
 
 const (
 	KeySize = sha256.Size
+
+	// clean is the stale index of a tree with no stale nodes
+	clean = math.MaxInt
 )
 
 var (
@@ -28,22 +31,51 @@ type (
 		Len() int
 		Depth() int
 		Keys() []*Key
+		Proof(Key) (*Proof, bool)
+		Version() Version
 	}
+	// tree keeps its keys sorted and caches every level of nodes, the
+	// leaves first. A key pairs with its neighbour by index, so a change
+	// at index i moves the pairing of every later key: Add and Remove
+	// shift the keys and leaves after i, and the next Root or Proof
+	// rehashes the nodes above them, about n-i in all. Changes cost O(n),
+	// but O(log n) at the end of the keys, and changes between Root calls
+	// are rehashed once. Pairing keys otherwise would change the roots,
+	// a V3 tree pairs them by their bits and is a trie instead.
+	// It also caches the sums of the LeafHash of the keys before each
+	// index, which summarize any range of keys for Sync, and the LeafHash
+	// of each key of a V1 tree, whose leaves are the keys.
 	tree struct {
 		// depth int
-		keys keys
-		m    sync.RWMutex
-		i    map[Key]struct{}
+		keys   keys
+		m      sync.RWMutex
+		i      map[Key]struct{}
+		v      Version
+		levels [][]Key
+		hashes []Key
+		sums   []Key
+		stale  int
 	}
 	keys []*Key
 )
 
-func New() Tree {
-	return &tree{
-		keys: []*Key{},
-		m:    sync.RWMutex{},
-		i:    map[Key]struct{}{},
+func New(opts ...Option) Tree {
+	t := &tree{
+		keys:   []*Key{},
+		m:      sync.RWMutex{},
+		i:      map[Key]struct{}{},
+		v:      V1,
+		levels: [][]Key{{}},
+		sums:   []Key{{}},
+		stale:  clean,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.v == V3 {
+		return &trie{}
 	}
+	return t
 }
 
 func (ks keys) Less(i, j int) bool {
@@ -65,8 +97,20 @@ func (t *tree) Add(k Key) {
 		return
 	}
 	t.i[k] = struct{}{}
-	t.keys = append(t.keys, &k)
-	sort.Stable(t.keys)
+	i := t.search(k)
+	t.keys = append(t.keys, nil)
+	copy(t.keys[i+1:], t.keys[i:])
+	t.keys[i] = &k
+	leaves := append(t.levels[0], Key{})
+	copy(leaves[i+1:], leaves[i:])
+	leaves[i] = t.v.leaf(k)
+	t.levels[0] = leaves
+	if t.v == V1 {
+		t.hashes = append(t.hashes, Key{})
+		copy(t.hashes[i+1:], t.hashes[i:])
+		t.hashes[i] = LeafHash(k[:])
+	}
+	t.invalidate(i)
 }
 
 func (t *tree) Remove(k Key) {
@@ -77,41 +121,150 @@ func (t *tree) Remove(k Key) {
 		return
 	}
 	delete(t.i, k)
-	for i, rk := range t.keys {
-		if k == *rk {
-			t.keys = append(t.keys[:i], t.keys[i+1:]...)
-			return
+	i := t.search(k)
+	t.keys = append(t.keys[:i], t.keys[i+1:]...)
+	t.levels[0] = append(t.levels[0][:i], t.levels[0][i+1:]...)
+	if t.v == V1 {
+		t.hashes = append(t.hashes[:i], t.hashes[i+1:]...)
+	}
+	t.invalidate(i)
+}
+
+// search returns the index of k in the sorted keys, or where it would go.
+func (t *tree) search(k Key) int {
+	return sort.Search(len(t.keys), func(i int) bool {
+		return bytes.Compare(t.keys[i][:], k[:]) >= 0
+	})
+}
+
+// span returns the index of the first key in r and of the key after the
+// last.
+func (t *tree) span(r Range) (int, int) {
+	i := t.search(r.lo())
+	n := sort.Search(len(t.keys)-i, func(j int) bool {
+		return !r.contains(*t.keys[i+j])
+	})
+	return i, i + n
+}
+
+// keysIn returns a copy of the keys in r, sorted.
+func (t *tree) keysIn(r Range) []Key {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	i, j := t.span(r)
+	out := make([]Key, j-i)
+	for n := range out {
+		out[n] = *t.keys[i+n]
+	}
+	return out
+}
+
+// summary returns the summary of the keys in r from the cached sums.
+func (t *tree) summary(r Range) Summary {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	i, j := t.span(r)
+	if i == j {
+		return Summary{}
+	}
+	return Summary{Count: j - i, Hash: subKey(t.sums[j], t.sums[i])}
+}
+
+// invalidate marks the nodes above leaf i and later leaves as stale.
+func (t *tree) invalidate(i int) {
+	if i < t.stale {
+		t.stale = i
+	}
+}
+
+// rlockFresh takes the read lock with no stale nodes, so readers of a
+// tree that has not changed do not wait on each other.
+func (t *tree) rlockFresh() {
+	t.m.RLock()
+	for t.stale != clean {
+		t.m.RUnlock()
+		t.m.Lock()
+		t.update()
+		t.m.Unlock()
+		t.m.RLock()
+	}
+}
+
+// update recomputes the stale nodes of every level above the leaves.
+func (t *tree) update() {
+	if t.stale == clean {
+		return
+	}
+	from := t.stale
+	if n := len(t.keys); from > n {
+		from = n
+	}
+	hashes := t.levels[0]
+	if t.v == V1 {
+		hashes = t.hashes
+	}
+	sums := t.sums[:from+1]
+	for i := from; i < len(t.keys); i++ {
+		sums = append(sums, addKey(sums[i], hashes[i]))
+	}
+	t.sums = sums
+	j := 0
+	for ; len(t.levels[j]) > 1; j++ {
+		row := t.levels[j]
+		n := (len(row) + 1) / 2
+		if j+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		up := t.levels[j+1]
+		if len(up) > n {
+			up = up[:n]
+		}
+		for len(up) < n {
+			up = append(up, Key{})
+		}
+		from /= 2
+		for p := from; p < n; p++ {
+			if 2*p+1 == len(row) {
+				up[p] = t.v.odd(row[2*p])
+			} else {
+				up[p] = t.v.node(row[2*p], row[2*p+1])
+			}
 		}
+		t.levels[j+1] = up
 	}
+	t.levels = t.levels[:j+1]
+	t.stale = clean
 }
 
-func buildTree(p keys) Key {
+func buildTree(v Version, p keys) Key {
+	if len(p) == 0 {
+		return v.empty()
+	}
+	if v == V3 {
+		return buildTrie(p, 0)
+	}
+	row := v.leaves(p)
+	for len(row) > 1 {
+		row = buildRow(v, row)
+	}
+	return *row[0]
+}
+
+// buildRow hashes pairs of nodes into the row above, and the odd node out
+// as the version pads it.
+func buildRow(v Version, p keys) keys {
 	l := len(p)
-	switch l {
-	case 0:
-		return Key{}
-	case 1:
-		return *p[0]
-	}
-	row := keys{}
-	padded := l%2 != 0
-	for i := 0 ; i < l ; i +=2 {
-		pl, pr := i, i + 1
+	row := make(keys, 0, (l+1)/2)
+	for i := 0; i < l; i += 2 {
 		k := Key{}
-		if i+1 == l && padded {
-			k = sha256.Sum256(p[pl][:])
+		if i+1 == l {
+			k = v.odd(*p[i])
 		} else {
-			k = sha256.Sum256(
-				append(p[pl][:],p[pr][:]...),
-			)
-		}
-		row = append(row, (*Key)(&k))
-		switch l {
-		case 1, 2:
-			return k
+			k = v.node(*p[i], *p[i+1])
 		}
+		row = append(row, &k)
 	}
-	return buildTree(row)
+	return row
 }
 
 // func (t *tree) buildTree() Key {
@@ -149,10 +302,17 @@ func buildTree(p keys) Key {
 // }
 
 func (t *tree) Root() Key {
-	t.m.RLock()
+	t.rlockFresh()
 	defer t.m.RUnlock()
 	// return t.buildTree()
-	return buildTree(t.keys)
+	if len(t.keys) == 0 {
+		return t.v.empty()
+	}
+	return t.levels[len(t.levels)-1][0]
+}
+
+func (t *tree) Version() Version {
+	return t.v
 }
 
 func (t *tree) Len() int {
@@ -1,9 +1,13 @@
 package merkle
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"log"
+	"math/rand"
+	"sort"
 
 	// "log"
 	// "log"
@@ -27,8 +31,9 @@ var (
 	}
 	r0 = decodeBase64Panic("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
 	r1 Key = sha256.Sum256([]byte("k1"))
+	// keys are sorted, k2 comes before k1
 	r2 Key = sha256.Sum256(
-		append(testKeys[0][:], testKeys[1][:]...),
+		append(testKeys[1][:], testKeys[0][:]...),
 	)
 	rootKeys []Key = []Key{
 		// decodeBase64Panic("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="),
@@ -116,6 +121,187 @@ func TestRoots(t *testing.T) {
 	// }
 }
 
+// roots of the first n testKeys, for each version
+var testVersionRoots = map[Version][]string{
+	V1: {
+		"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"arnx6499M4j0+dWG9m6Z/VQIDfLERvDlhmiwnAihbdA=",
+		"BJZyB+MDcuc8TEQPjOueVJENQ0IjAum2ELZc/ctijSs=",
+		"9OUAHBYpJdoRPclohdJq5DAnfaYNMt0FwDrp8lBZyQE=",
+		"+La1E+yN+/paWI1w+cmEYhsx+L0ygoCBfILTxp7KgpU=",
+		"+HtnLLx+HrMvdGbKRVDu1nRbeoLRiW9Ov/Ih40MiCSQ=",
+		"mjNdBrApmWWQWTTp61tPLSX8dSMUFOOUuPvA1ghoiH8=",
+		"OQmDLTB6/MZV5iv7BOnDm3W5j5uiOFoBzfTkaUDfJ5c=",
+		"wYGq5rFDVu+f9xYh3W581cHYHTdiZqBCvqSrWrmkTnA=",
+		"X0eJZYN3koaz7D/kHzKF1nJ4HUFJNDIMtoLcZPb2r1E=",
+		"5KDhH2v7l7h5VWVKdZkiqsGoxSG1sYwEzd3Y6TI/ZZs=",
+		"TjxVN89a3YbSJbQcE2FKy4e6KwiYaWYTnUR690U+nBQ=",
+		"kDiSaDyV5F/HRViHKFfz0ZKY/s+cFt/5xvfBK7Nlb+Q=",
+	},
+	V2: {
+		"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"lUtDjj8s9q1BdQC9qO1fo9Yc305amqmJXLsnxBaKGIQ=",
+		"7DkKRj+7BKL+TctoDEqE1T+ZsHeryseGusZoqYhke/Q=",
+		"wlmsm2vvNsa10/FTZCIUXb4ZcsfYbW50DceaEo3B6Ts=",
+		"+6bLOykJzJ++9kqQGXbWS6sa0BFQgaZ6wFhlGI1XmPc=",
+		"b2FNl+wcfKtj4TDOYU8QAypJmUIzxvCp751hD/ysPuw=",
+		"L4dNlNGd4CxzT8VyUhp+uriaaI8FIRKaGxGx87RnpdM=",
+		"8gmvdOGu1dBbT2XjIpY9Alxc3CiaxIKh+6dbcos0Jck=",
+		"UTzEVr1hOnmOWnAqriDfkGcfvM5PXadCs6ijEHrVgS4=",
+		"62ZuuuQ9gIHTQVHrmS2Q6eaxZ2v/jmyF4+Fji6kxSyM=",
+		"+DwRdMxm8uRQw84Iai8Jns+t/pSUCScZMfWtVFxOleQ=",
+		"qqFktQRgRBgzSKKEMvAAGE0h9+JdcqgDuXZfeG3nz3g=",
+		"mPw3TpM7i9NbhLbA/O4+5Uy4UMhy2gHmAuJoejQy2iA=",
+	},
+	V3: {
+		"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"lUtDjj8s9q1BdQC9qO1fo9Yc305amqmJXLsnxBaKGIQ=",
+		"7DkKRj+7BKL+TctoDEqE1T+ZsHeryseGusZoqYhke/Q=",
+		"wlmsm2vvNsa10/FTZCIUXb4ZcsfYbW50DceaEo3B6Ts=",
+		"6MhXDPsV4OupuVEpLrCbbt9Z2UaZW/Cg9bhh7QvwKMI=",
+		"yn9Q0Gtg4PXmxAHit14UXOHhfvSY+LUE+Ap/+a+n0c4=",
+		"Uwt5LZETyl5Qt3v6T/ho/uhZEyw+wdv/oMqUaI4W/gI=",
+		"nX6B/Ve9cxcbW3Hs/PVtGcVOPGaWIbwrDFcIQLrDTyA=",
+		"aDG6v3RosjUxsAa9pBBrj13oPAMNgb7TqLrjVxn99rI=",
+		"dCfiBcObuuHeBkgobWQvwBk4+dTkTAxeh1j1cSnWj7U=",
+		"vchd1JtyWszCafYYcXv+F3xvUYIiShiz+VHemK+VveM=",
+		"p1/+nb/Sco7hrRw6dNEN5ey43gVwnoOOipc6/VnoUO0=",
+		"iH6GV3/f7l5sTgmVsAiO5bTfvU1tcwytBVDhVyo96FY=",
+	},
+}
+
+func TestVersionRoots(t *testing.T) {
+	for v, roots := range testVersionRoots {
+		m := New(WithVersion(v))
+		if m.Version() != v {
+			t.Fatalf("expecting version %v, is %v", v, m.Version())
+		}
+		for i := 0; i <= len(testKeys); i++ {
+			if i > 0 {
+				m.Add(testKeys[i-1])
+			}
+			if r := m.Root(); r != decodeBase64Panic(roots[i]) {
+				t.Errorf("v%v, %v keys: expecting root %v, is %v", v, i, roots[i], r.String())
+			}
+		}
+	}
+	if New(WithVersion(unknownVersion)).Version() != V1 {
+		t.Errorf("unknown version should keep V1")
+	}
+}
+
+func TestVersionV2(t *testing.T) {
+	m := New(WithVersion(V2))
+	l := NewLog()
+	for _, k := range testKeys {
+		m.Add(k)
+	}
+	// a V2 root is the RFC 6962 root of the sorted keys
+	for _, k := range m.Keys() {
+		l.Append(k[:])
+	}
+	if m.Root() != l.Root() {
+		t.Errorf("expecting log root %v, is %v", l.Root().String(), m.Root().String())
+	}
+	root := m.Root()
+	for i, k := range m.Keys() {
+		p, _ := m.Proof(*k)
+		if !V2.VerifyProof(root, *k, p) {
+			t.Fatalf("proof of %v does not verify", k)
+		}
+		if !VerifyInclusion(root, LeafHash(k[:]), p) {
+			t.Fatalf("proof of %v is not a log inclusion proof", k)
+		}
+		if VerifyProof(root, *k, p) {
+			t.Fatalf("proof of %v verifies as V1", k)
+		}
+		// an interior node is not a key
+		if len(p.Path) > 0 {
+			node := Key{}
+			if i%2 == 0 {
+				node = nodeHash(LeafHash(k[:]), p.Path[0])
+			} else {
+				node = nodeHash(p.Path[0], LeafHash(k[:]))
+			}
+			up := &Proof{Index: p.Index / 2, Size: (p.Size + 1) / 2, Path: p.Path[1:]}
+			if V2.VerifyProof(root, node, up) {
+				t.Fatalf("interior node of %v verifies as a key", k)
+			}
+		}
+	}
+}
+
+func TestVersionV3(t *testing.T) {
+	m := New(WithVersion(V3))
+	for i := 0; i < 4096; i++ {
+		m.Add(randomKey(i))
+	}
+	root := m.Root()
+	for _, k := range m.Keys()[:100] {
+		p, ok := m.Proof(*k)
+		if !ok || !V3.VerifyProof(root, *k, p) {
+			t.Fatalf("proof of %v does not verify", k)
+		}
+		if VerifyProof(root, *k, p) {
+			t.Fatalf("proof of %v verifies as V1", k)
+		}
+		// an interior node is not a key
+		node := Key{}
+		if p.Index%2 == 0 {
+			node = nodeHash(LeafHash(k[:]), p.Path[0])
+		} else {
+			node = nodeHash(p.Path[0], LeafHash(k[:]))
+		}
+		up := &Proof{Index: p.Index / 2, Size: p.Size / 2, Path: p.Path[1:]}
+		if V3.VerifyProof(root, node, up) {
+			t.Fatalf("interior node of %v verifies as a key", k)
+		}
+	}
+	if _, ok := m.Proof(randomKey(-1)); ok {
+		t.Fatalf("expecting no proof of a missing key")
+	}
+	// random keys make a trie about log n deep, and a change only marks
+	// the nodes above its key
+	if d := m.Depth(); d < 13 || d > 40 {
+		t.Fatalf("bad depth %v", d)
+	}
+	k := randomKey(-2)
+	m.Add(k)
+	if n := countStale(m.(*trie).root); n >= m.Depth() {
+		t.Fatalf("add marked %v nodes stale, depth %v", n, m.Depth())
+	}
+	m.Root()
+	m.Remove(k)
+	if n := countStale(m.(*trie).root); n >= m.Depth() {
+		t.Fatalf("remove marked %v nodes stale, depth %v", n, m.Depth())
+	}
+	if m.Root() != root || m.Len() != 4096 {
+		t.Fatalf("bad root after removing the key added")
+	}
+}
+
+// countStale returns the number of stale nodes below n.
+func countStale(n *node) int {
+	if n == nil || !n.stale {
+		return 0
+	}
+	return 1 + countStale(n.kids[0]) + countStale(n.kids[1])
+}
+
+func TestVersionV1SecondPreimage(t *testing.T) {
+	m := New()
+	for _, k := range testKeys[:4] {
+		m.Add(k)
+	}
+	// V1 takes the parent of the first two keys for a key
+	ks := m.Keys()
+	node := V1.node(*ks[0], *ks[1])
+	p := &Proof{Index: 0, Size: 2, Path: []Key{V1.node(*ks[2], *ks[3])}}
+	if !VerifyProof(m.Root(), node, p) {
+		t.Errorf("expecting V1 to verify an interior node")
+	}
+}
+
 func TestDepth(t *testing.T) {
 	m := New()
 	// 0 keys
@@ -154,6 +340,165 @@ func TestDepth(t *testing.T) {
 	}
 }
 
+func TestIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, v := range []Version{V1, V2, V3} {
+		m := New(WithVersion(v))
+		set := map[Key]struct{}{}
+		for i := 0; i < 2000; i++ {
+			k := randomKey(rng.Intn(300))
+			if _, has := set[k]; has && rng.Intn(2) == 0 {
+				m.Remove(k)
+				delete(set, k)
+			} else {
+				m.Add(k)
+				set[k] = struct{}{}
+			}
+			// batch some changes between roots
+			if rng.Intn(3) != 0 {
+				continue
+			}
+			ks := m.Keys()
+			if len(ks) != len(set) || !sort.IsSorted(keys(ks)) {
+				t.Fatalf("v%v: keys out of order", v)
+			}
+			root := m.Root()
+			if want := buildTree(v, ks); root != want {
+				t.Fatalf("v%v, %v keys: expecting root %v, is %v", v, len(ks), want.String(), root.String())
+			}
+			sum := Summary{Count: len(ks)}
+			for _, k := range ks {
+				sum.Hash = addKey(sum.Hash, LeafHash(k[:]))
+			}
+			if s := m.(ranger).summary(Range{}); s != sum {
+				t.Fatalf("v%v, %v keys: bad summary %v", v, len(ks), s)
+			}
+			if len(ks) > 0 {
+				k := *ks[rng.Intn(len(ks))]
+				if p, _ := m.Proof(k); !v.VerifyProof(root, k, p) {
+					t.Fatalf("v%v, %v keys: proof does not verify", v, len(ks))
+				}
+			}
+		}
+	}
+}
+
+// test that readers and writers can share a tree
+func TestConcurrentRoot(t *testing.T) {
+	m := New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			m.Add(randomKey(i))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		m.Root()
+		m.Proof(randomKey(i / 2))
+	}
+	<-done
+	ks := m.Keys()
+	if m.Root() != buildTree(V1, ks) {
+		t.Fatalf("bad root")
+	}
+}
+
+// randomKey returns the key of i.
+func randomKey(i int) Key {
+	b := [8]byte{}
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	return sha256.Sum256(b[:])
+}
+
+// benchmarkTree returns a tree of n random keys, and the sorted keys.
+func benchmarkTree(b *testing.B, n int, opts ...Option) (Tree, []Key) {
+	b.Helper()
+	ks := make([]Key, n)
+	for i := range ks {
+		ks[i] = randomKey(i)
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		return bytes.Compare(ks[i][:], ks[j][:]) < 0
+	})
+	m := New(opts...)
+	// adding in order appends
+	for _, k := range ks {
+		m.Add(k)
+	}
+	m.Root()
+	return m, ks
+}
+
+// BenchmarkAddRoot_1M adds and removes random keys in a tree of 1M keys,
+// an insert moves every later key so it costs O(n), see V3.
+func BenchmarkAddRoot_1M(b *testing.B) {
+	m, _ := benchmarkTree(b, 1<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := randomKey(-i - 1)
+		m.Add(k)
+		m.Root()
+		m.Remove(k)
+	}
+}
+
+// BenchmarkAddRoot_1M_V3 adds and removes random keys in a V3 tree of 1M
+// keys, which only rehashes their path.
+func BenchmarkAddRoot_1M_V3(b *testing.B) {
+	m, _ := benchmarkTree(b, 1<<20, WithVersion(V3))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := randomKey(-i - 1)
+		m.Add(k)
+		m.Root()
+		m.Remove(k)
+	}
+}
+
+// BenchmarkAddRoot_1M_Last adds keys after every other key, which only
+// rehashes their path.
+func BenchmarkAddRoot_1M_Last(b *testing.B) {
+	m, _ := benchmarkTree(b, 1<<20)
+	k := Key{}
+	for i := range k {
+		k[i] = 0xff
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(k[KeySize-8:], uint64(i))
+		m.Add(k)
+		m.Root()
+	}
+}
+
+// BenchmarkRoot_1M reads the cached root.
+func BenchmarkRoot_1M(b *testing.B) {
+	m, _ := benchmarkTree(b, 1<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Root()
+	}
+}
+
+// BenchmarkProof_1M proves random keys.
+func BenchmarkProof_1M(b *testing.B) {
+	m, ks := benchmarkTree(b, 1<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Proof(ks[i%len(ks)])
+	}
+}
+
+// BenchmarkProof_1M_V3 proves random keys of a V3 tree.
+func BenchmarkProof_1M_V3(b *testing.B) {
+	m, ks := benchmarkTree(b, 1<<20, WithVersion(V3))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Proof(ks[i%len(ks)])
+	}
+}
+
 func decodeBase64Panic(s string) Key {
 	b, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
@@ -0,0 +1,282 @@
+package merkle
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxTrieProof is the longest path of a V3 proof, whose Size is
+	// 1<<len(Path)
+	maxTrieProof = bits.UintSize - 2
+)
+
+var (
+	_ Tree   = &trie{}
+	_ ranger = &trie{}
+)
+
+type (
+	// trie is a V3 tree, a crit-bit tree of its keys: a node splits its
+	// keys by the first bit where they differ, those with the bit unset on
+	// the left, so its leaves are sorted and a key only changes the nodes
+	// above it, about log n of them for random keys. Add and Remove mark
+	// those nodes stale and the next Root or Proof rehashes them. Each
+	// node also caches the number and the sum of the LeafHash of its
+	// keys, which summarize any range of keys for Sync.
+	trie struct {
+		root *node
+		m    sync.RWMutex
+		n    int
+	}
+	// node is a leaf if key is set, or else has two kids
+	node struct {
+		kids  [2]*node
+		key   *Key
+		bit   int
+		hash  Key
+		sum   Key
+		count int
+		depth int
+		stale bool
+	}
+)
+
+// bitOf returns bit b of k, the most significant bit first.
+func bitOf(k Key, b int) int {
+	return int(k[b/8]>>(7-b%8)) & 1
+}
+
+// critBit returns the first bit where a and b differ, or KeySize*8.
+func critBit(a, b Key) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return KeySize * 8
+}
+
+// closest returns the leaf that k would be, which shares the most bits
+// with k. The trie must not be empty.
+func (t *trie) closest(k Key) *node {
+	n := t.root
+	for n.key == nil {
+		n = n.kids[bitOf(k, n.bit)]
+	}
+	return n
+}
+
+// first returns the first leaf below n.
+func first(n *node) *node {
+	for n.key == nil {
+		n = n.kids[0]
+	}
+	return n
+}
+
+func (t *trie) Add(k Key) {
+	leaf := &node{key: &k, hash: LeafHash(k[:]), count: 1, depth: 1}
+	leaf.sum = leaf.hash
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.root == nil {
+		t.root = leaf
+		t.n++
+		return
+	}
+	d := critBit(k, *t.closest(k).key)
+	if d == KeySize*8 {
+		return
+	}
+	p := &t.root
+	for n := *p; n.key == nil && n.bit < d; n = *p {
+		n.stale = true
+		p = &n.kids[bitOf(k, n.bit)]
+	}
+	up := &node{bit: d, stale: true}
+	b := bitOf(k, d)
+	up.kids[b], up.kids[1-b] = leaf, *p
+	*p = up
+	t.n++
+}
+
+func (t *trie) Remove(k Key) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.root == nil || *t.closest(k).key != k {
+		return
+	}
+	t.n--
+	if t.root.key != nil {
+		t.root = nil
+		return
+	}
+	// replace the parent of the leaf with its sibling
+	for p := &t.root; ; {
+		n := *p
+		b := bitOf(k, n.bit)
+		if n.kids[b].key != nil {
+			*p = n.kids[1-b]
+			return
+		}
+		n.stale = true
+		p = &n.kids[b]
+	}
+}
+
+// update recomputes the stale nodes below n.
+func (t *trie) update(n *node) {
+	if n == nil || !n.stale {
+		return
+	}
+	l, r := n.kids[0], n.kids[1]
+	t.update(l)
+	t.update(r)
+	n.hash = nodeHash(l.hash, r.hash)
+	n.sum = addKey(l.sum, r.sum)
+	n.count = l.count + r.count
+	n.depth = l.depth + 1
+	if r.depth > l.depth {
+		n.depth = r.depth + 1
+	}
+	n.stale = false
+}
+
+// rlockFresh takes the read lock with no stale nodes, like the tree's.
+func (t *trie) rlockFresh() {
+	t.m.RLock()
+	for t.root != nil && t.root.stale {
+		t.m.RUnlock()
+		t.m.Lock()
+		t.update(t.root)
+		t.m.Unlock()
+		t.m.RLock()
+	}
+}
+
+func (t *trie) Root() Key {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	if t.root == nil {
+		return V3.empty()
+	}
+	return t.root.hash
+}
+
+func (t *trie) Version() Version {
+	return V3
+}
+
+func (t *trie) Len() int {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.n
+}
+
+// Depth returns the number of nodes on the longest path from the root to
+// a key.
+func (t *trie) Depth() int {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	if t.root == nil {
+		return 0
+	}
+	return t.root.depth
+}
+
+// Keys returns the keys in order, in a new slice.
+func (t *trie) Keys() []*Key {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return appendLeaves(make([]*Key, 0, t.n), t.root)
+}
+
+// appendLeaves appends the keys below n in order.
+func appendLeaves(ks []*Key, n *node) []*Key {
+	switch {
+	case n == nil:
+	case n.key != nil:
+		ks = append(ks, n.key)
+	default:
+		ks = appendLeaves(ks, n.kids[0])
+		ks = appendLeaves(ks, n.kids[1])
+	}
+	return ks
+}
+
+// Proof collects the siblings of k on the way up to the root, and the
+// side of k at each level, as a path in a full tree of 1<<len(Path) keys.
+// It fails for paths longer than maxTrieProof, which only keys chosen to
+// share long prefixes make.
+func (t *trie) Proof(k Key) (*Proof, bool) {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	if t.root == nil || *t.closest(k).key != k {
+		return nil, false
+	}
+	path, index := []Key{}, 0
+	for n := t.root; n.key == nil; {
+		b := bitOf(k, n.bit)
+		path = append(path, n.kids[1-b].hash)
+		index = index<<1 | b
+		n = n.kids[b]
+	}
+	if len(path) > maxTrieProof {
+		return nil, false
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return &Proof{Index: index, Size: 1 << len(path), Path: path}, true
+}
+
+// within returns the node whose keys are the keys of t in r, or nil.
+func (t *trie) within(r Range) *node {
+	n := t.root
+	for n != nil && n.key == nil && n.bit < r.Bits {
+		n = n.kids[bitOf(r.Prefix, n.bit)]
+	}
+	if n == nil || !r.contains(*first(n).key) {
+		return nil
+	}
+	return n
+}
+
+// keysIn returns a copy of the keys in r, sorted.
+func (t *trie) keysIn(r Range) []Key {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	ks := appendLeaves(nil, t.within(r))
+	out := make([]Key, len(ks))
+	for i, k := range ks {
+		out[i] = *k
+	}
+	return out
+}
+
+// summary returns the summary of the keys in r from the cached sums.
+func (t *trie) summary(r Range) Summary {
+	t.rlockFresh()
+	defer t.m.RUnlock()
+	n := t.within(r)
+	if n == nil {
+		return Summary{}
+	}
+	return Summary{Count: n.count, Hash: n.sum}
+}
+
+// buildTrie returns the V3 root of sorted keys whose bits before bit
+// match.
+func buildTrie(p keys, bit int) Key {
+	if len(p) == 1 {
+		return LeafHash(p[0][:])
+	}
+	for ; ; bit++ {
+		i := sort.Search(len(p), func(i int) bool { return bitOf(*p[i], bit) == 1 })
+		if i > 0 && i < len(p) {
+			return nodeHash(buildTrie(p[:i], bit+1), buildTrie(p[i:], bit+1))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package merkle
+
+import (
+	"crypto/sha256"
+)
+
+const (
+	unknownVersion Version = iota
+	// V1 hashes nodes as sha256(left||right), uses a lone key as the
+	// root and hashes an odd node out alone. It is the default, so roots
+	// stay the same, but an interior node can be presented as a key.
+	V1
+	// V2 hashes keys as sha256(0x00||key) and nodes as
+	// sha256(0x01||left||right) and promotes an odd node out unchanged,
+	// so its root is the RFC 6962 root of the sorted keys.
+	V2
+	// V3 hashes keys and nodes as V2 but pairs keys by their bits instead
+	// of their index: the keys whose first bits match share a subtree,
+	// split at the first bit where they differ. A change only rehashes the
+	// nodes above its key, about log n of them for random keys, where V1
+	// and V2 rehash the nodes above every later key.
+	V3
+)
+
+type (
+	// Version is how a Tree hashes its keys and nodes
+	Version int
+	// Option configures a New Tree.
+	Option func(*tree)
+)
+
+// WithVersion makes a Tree hash with v instead of V1.
+func WithVersion(v Version) Option {
+	return func(t *tree) {
+		if v == V1 || v == V2 || v == V3 {
+			t.v = v
+		}
+	}
+}
+
+// empty returns the root of no keys.
+func (v Version) empty() Key {
+	if v != V1 {
+		return sha256.Sum256(nil)
+	}
+	return Key{}
+}
+
+// leaf returns the bottom node of a key.
+func (v Version) leaf(k Key) Key {
+	if v != V1 {
+		return LeafHash(k[:])
+	}
+	return k
+}
+
+// node returns the parent of two nodes.
+func (v Version) node(l, r Key) Key {
+	if v != V1 {
+		return nodeHash(l, r)
+	}
+	return sha256.Sum256(append(l[:], r[:]...))
+}
+
+// odd returns the parent of an odd node out.
+func (v Version) odd(k Key) Key {
+	if v != V1 {
+		return k
+	}
+	return sha256.Sum256(k[:])
+}
+
+// leaves returns the bottom row of keys.
+func (v Version) leaves(p keys) keys {
+	if v == V1 {
+		return p
+	}
+	row := make(keys, len(p))
+	for i, k := range p {
+		l := v.leaf(*k)
+		row[i] = &l
+	}
+	return row
+}
@@ -0,0 +1,136 @@
+package time
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// SystemClock is the real time
+	SystemClock Clock = systemClock{}
+
+	_ Clock = &ManualClock{}
+)
+
+type (
+	// Clock tells the time, so time-dependent code can be tested with a
+	// ManualClock.
+	Clock interface {
+		// Now returns the current time
+		Now() time.Time
+		// After sends the time on the channel once d has passed
+		After(d time.Duration) <-chan time.Time
+	}
+	systemClock struct{}
+
+	// ManualClock is a Clock that only moves when told to.
+	ManualClock struct {
+		now     time.Time
+		waiters []waiter
+		mutex   sync.Mutex
+	}
+	// waiter is a pending After of a ManualClock
+	waiter struct {
+		at time.Time
+		ch chan time.Time
+	}
+
+	// Option configures a NewTMap or a limiter.
+	Option func(*options)
+	// options are the settings of NewTMap and the limiters
+	options struct {
+		clock   Clock
+		refresh bool
+	}
+)
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewManualClock returns a clock stopped at now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time the clock is set to.
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// After fires once the clock is advanced by d or more.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the After channels that
+// are due, earliest first.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to now, which may be in the past.
+func (c *ManualClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(now)
+}
+
+// Waiters returns the number of pending After channels.
+func (c *ManualClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// set moves the clock and fires due waiters, with the lock held.
+func (c *ManualClock) set(now time.Time) {
+	c.now = now
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+	i := 0
+	for ; i < len(c.waiters) && !c.waiters[i].at.After(now); i++ {
+		c.waiters[i].ch <- c.waiters[i].at
+	}
+	c.waiters = append(c.waiters[:0], c.waiters[i:]...)
+}
+
+// WithClock makes a TMap or limiter read the time from c instead of
+// SystemClock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithRefreshOnGet makes Get of a TMapKV refresh the access time of the
+// key, which DropLeastRecentlyAccessed drops by.
+func WithRefreshOnGet(on bool) Option {
+	return func(o *options) {
+		o.refresh = on
+	}
+}
+
+// newOptions applies opts over the defaults.
+func newOptions(opts []Option) options {
+	o := options{clock: SystemClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
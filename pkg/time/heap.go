@@ -0,0 +1,119 @@
+package time
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	minSide = 0
+	maxSide = 1
+)
+
+type (
+	// tItem is a key of a tMap on both heaps of its index
+	tItem[T comparable] struct {
+		v     T
+		t     time.Time
+		seq   uint64
+		index [2]int
+	}
+	// tHeap orders items by time and then sequence, oldest first on the
+	// min side and newest first on the max side
+	tHeap[T comparable] struct {
+		items []*tItem[T]
+		side  int
+	}
+	// tIndex keeps the keys of a tMap on a min and a max heap, so the
+	// oldest and newest are found in O(1) and removed in O(log n)
+	tIndex[T comparable] struct {
+		items map[T]*tItem[T]
+		min   tHeap[T]
+		max   tHeap[T]
+	}
+)
+
+func (h *tHeap[T]) Len() int { return len(h.items) }
+
+func (h *tHeap[T]) Less(i, j int) bool {
+	if h.side == maxSide {
+		i, j = j, i
+	}
+	a, b := h.items[i], h.items[j]
+	if a.t.Equal(b.t) {
+		return a.seq < b.seq
+	}
+	return a.t.Before(b.t)
+}
+
+func (h *tHeap[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index[h.side] = i
+	h.items[j].index[h.side] = j
+}
+
+func (h *tHeap[T]) Push(x any) {
+	it := x.(*tItem[T])
+	it.index[h.side] = len(h.items)
+	h.items = append(h.items, it)
+}
+
+func (h *tHeap[T]) Pop() any {
+	n := len(h.items) - 1
+	it := h.items[n]
+	h.items[n] = nil // avoid memory leaks
+	h.items = h.items[:n]
+	it.index[h.side] = -1
+	return it
+}
+
+// top returns the first item of the heap, nil if empty.
+func (h *tHeap[T]) top() *tItem[T] {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return h.items[0]
+}
+
+// newTIndex returns an empty index.
+func newTIndex[T comparable]() *tIndex[T] {
+	return &tIndex[T]{
+		items: map[T]*tItem[T]{},
+		min:   tHeap[T]{side: minSide},
+		max:   tHeap[T]{side: maxSide},
+	}
+}
+
+// set adds v at t and seq, or moves it there if present.
+func (x *tIndex[T]) set(v T, t time.Time, seq uint64) {
+	if it, ok := x.items[v]; ok {
+		it.t = t
+		it.seq = seq
+		heap.Fix(&x.min, it.index[minSide])
+		heap.Fix(&x.max, it.index[maxSide])
+		return
+	}
+	it := &tItem[T]{v: v, t: t, seq: seq}
+	x.items[v] = it
+	heap.Push(&x.min, it)
+	heap.Push(&x.max, it)
+}
+
+// remove removes v, if present.
+func (x *tIndex[T]) remove(v T) {
+	if it, ok := x.items[v]; ok {
+		heap.Remove(&x.min, it.index[minSide])
+		heap.Remove(&x.max, it.index[maxSide])
+		delete(x.items, v)
+	}
+}
+
+// oldest returns the oldest item, nil if empty.
+func (x *tIndex[T]) oldest() *tItem[T] {
+	return x.min.top()
+}
+
+// newest returns the newest item, nil if empty.
+func (x *tIndex[T]) newest() *tItem[T] {
+	return x.max.top()
+}
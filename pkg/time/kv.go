@@ -0,0 +1,138 @@
+package time
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	_ TMapKV[int, int] = &tMapKV[int, int]{}
+)
+
+type (
+	// TMapKV is a TMap that stores a value per key, with the time the key
+	// was added and the time it was last accessed.
+	TMapKV[K comparable, V any] interface {
+		Size() int
+		// Add sets the value of a key, created and accessed now, as for
+		// TMap it returns ErrFull when full and not dropping live keys
+		Add(K, V) (time.Time, error)
+		Capacity(...int) int
+		// Get returns the value and created time of a key
+		Get(K) (V, time.Time, bool)
+		// Accessed returns the last access time of a key, without
+		// refreshing it
+		Accessed(K) (time.Time, bool)
+		Delete(K) (V, bool)
+		Newest() (K, V, time.Time, bool)
+		Oldest() (K, V, time.Time, bool)
+		Each(func(K, V, time.Time) error) error
+		MaxAge(...time.Duration) time.Duration
+		OnExpire(func(K, V, time.Time))
+		Expire() int
+		Sweep(context.Context, time.Duration)
+	}
+
+	// tMapKV is a TMap core with the values of the keys and a second
+	// index, by accessed time
+	tMapKV[K comparable, V any] struct {
+		tCore[K, V]
+		refresh bool
+	}
+)
+
+// NewTMapKV, keys are expired by when they were added and, with
+// WithRefreshOnGet, accessed by Add and Get.
+func NewTMapKV[K comparable, V any](capacity int, policy DropPolicy, opts ...Option) TMapKV[K, V] {
+	o := newOptions(opts)
+	return &tMapKV[K, V]{
+		tCore: tCore[K, V]{
+			capacity: capacity,
+			policy:   policy,
+			M:        map[K]tEntry[V]{},
+			index:    newTIndex[K](),
+			accessed: newTIndex[K](),
+			clock:    o.clock,
+		},
+		refresh: o.refresh,
+	}
+}
+
+// Add
+func (s *tMapKV[K, V]) Add(k K, v V) (time.Time, error) {
+	s.mutex.Lock()
+	t, ks, es, err := s.add(k, v)
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, ks, es)
+	return t, err
+}
+
+// Get refreshes the access time if the map was made WithRefreshOnGet.
+func (s *tMapKV[K, V]) Get(k K) (v V, t time.Time, ok bool) {
+	if s.refresh {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+	} else {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+	}
+	e, ok := s.get(k)
+	if !ok {
+		return v, t, false
+	}
+	if s.refresh {
+		s.seq++
+		s.accessed.set(k, s.clock.Now().UTC(), s.seq)
+	}
+	return e.v, e.t, true
+}
+
+// Accessed
+func (s *tMapKV[K, V]) Accessed(k K) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.get(k); !ok {
+		return time.Time{}, false
+	}
+	return s.accessed.items[k].t, true
+}
+
+// Delete
+func (s *tMapKV[K, V]) Delete(k K) (V, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, ok := s.delete(k)
+	return e.v, ok
+}
+
+// Oldest removes expired keys first, they would be the oldest
+func (s *tMapKV[K, V]) Oldest() (K, V, time.Time, bool) {
+	k, e, ok := s.first()
+	return k, e.v, e.t, ok
+}
+
+// Newest
+func (s *tMapKV[K, V]) Newest() (K, V, time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	k, e, ok := s.newest()
+	return k, e.v, e.t, ok
+}
+
+// Each skips expired keys
+func (s *tMapKV[K, V]) Each(f func(K, V, time.Time) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.each(func(k K, e tEntry[V]) error {
+		return f(k, e.v, e.t)
+	})
+}
+
+// OnExpire sets a callback for every expired key removed from the map,
+// with its value and created time, it is called without the lock held.
+func (s *tMapKV[K, V]) OnExpire(f func(K, V, time.Time)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onExpire = f
+}
@@ -0,0 +1,138 @@
+package time
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTMapKV(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewTMapKV[string, int](0, DontDrop, WithClock(clock))
+	added, _ := m.Add("a", 1)
+	clock.Advance(time.Second)
+	m.Add("b", 2)
+	if v, at, ok := m.Get("a"); !ok || v != 1 || !at.Equal(added) {
+		t.Fatalf("bad get: %v %v %v", v, at, ok)
+	}
+	// Get does not refresh by default
+	if at, _ := m.Accessed("a"); !at.Equal(added) {
+		t.Fatalf("bad accessed: %v", at)
+	}
+	if k, v, _, _ := m.Oldest(); k != "a" || v != 1 {
+		t.Fatalf("bad oldest: %v %v", k, v)
+	}
+	if k, v, _, _ := m.Newest(); k != "b" || v != 2 {
+		t.Fatalf("bad newest: %v %v", k, v)
+	}
+	sum := 0
+	m.Each(func(_ string, v int, _ time.Time) error {
+		sum += v
+		return nil
+	})
+	if sum != 3 {
+		t.Fatalf("bad sum: %v", sum)
+	}
+	if v, ok := m.Delete("a"); !ok || v != 1 || m.Size() != 1 {
+		t.Fatalf("bad delete: %v %v", v, ok)
+	}
+
+	expired := map[string]int{}
+	m.OnExpire(func(k string, v int, _ time.Time) {
+		expired[k] = v
+	})
+	m.MaxAge(time.Second)
+	clock.Advance(time.Second)
+	if _, _, ok := m.Get("b"); ok {
+		t.Fatalf("b should be expired")
+	}
+	if m.Size() != 0 || expired["b"] != 2 {
+		t.Fatalf("b should be removed: %v", expired)
+	}
+}
+
+func TestTMapKV_Drop(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	fill := func(policy DropPolicy, opts ...Option) TMapKV[string, int] {
+		m := NewTMapKV[string, int](2, policy, append(opts, WithClock(clock))...)
+		m.Add("a", 1)
+		clock.Advance(time.Second)
+		m.Add("b", 2)
+		clock.Advance(time.Second)
+		m.Get("a")
+		m.Add("c", 3)
+		return m
+	}
+	has := func(m TMapKV[string, int]) (keys string) {
+		for _, k := range []string{"a", "b", "c"} {
+			if _, _, ok := m.Get(k); ok {
+				keys += k
+			}
+		}
+		return keys
+	}
+	for _, tt := range []struct {
+		policy  DropPolicy
+		refresh bool
+		keys    string
+	}{
+		{DropOldest, false, "bc"},
+		{DropNewest, false, "ab"},
+		{DropLeastRecentlyAccessed, false, "bc"},
+		{DropLeastRecentlyAccessed, true, "ac"},
+		{DontDrop, false, "ab"},
+		{DropExpired, false, "ab"},
+	} {
+		m := fill(tt.policy, WithRefreshOnGet(tt.refresh))
+		if keys := has(m); keys != tt.keys {
+			t.Fatalf("%v refresh %v: bad keys: %v, want %v", tt.policy, tt.refresh, keys, tt.keys)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		m := fill(DropRandom)
+		if _, _, ok := m.Get("c"); !ok || m.Size() != 2 {
+			t.Fatalf("the key just added should be kept: %v", has(m))
+		}
+	}
+
+	// DropExpired makes room with expired keys only
+	m := NewTMapKV[string, int](2, DropExpired, WithClock(clock))
+	m.MaxAge(2 * time.Second)
+	m.Add("a", 1)
+	clock.Advance(time.Second)
+	m.Add("b", 2)
+	clock.Advance(time.Second)
+	if _, err := m.Add("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if keys := has(m); keys != "bc" || m.Size() != 2 {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	if _, err := m.Add("d", 4); err != ErrFull {
+		t.Fatalf("should be full: %v", err)
+	}
+
+	// a smaller capacity drops as Add would
+	m = fill(DropLeastRecentlyAccessed, WithRefreshOnGet(true))
+	m.Capacity(1)
+	if keys := has(m); keys != "c" {
+		t.Fatalf("bad keys: %v", keys)
+	}
+}
+
+func TestTMapKV_Refresh(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewTMapKV[string, int](0, DontDrop, WithClock(clock), WithRefreshOnGet(true))
+	added, _ := m.Add("a", 1)
+	clock.Advance(time.Second)
+	if _, at, _ := m.Get("a"); !at.Equal(added) {
+		t.Fatalf("created time should be kept: %v", at)
+	}
+	if at, _ := m.Accessed("a"); !at.Equal(clock.Now()) {
+		t.Fatalf("bad accessed: %v", at)
+	}
+	// access does not keep a key from expiring
+	m.MaxAge(time.Second)
+	if _, _, ok := m.Get("a"); ok {
+		t.Fatalf("a should be expired")
+	}
+}
@@ -0,0 +1,285 @@
+package time
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	_ Limiter[int] = &limiter[int, *windowLog]{}
+	_ Limiter[int] = &limiter[int, *bucket]{}
+
+	// ErrWait is returned by Wait when the context ends before the key
+	// would be allowed.
+	ErrWait = fmt.Errorf("rate limit wait exceeds context deadline")
+)
+
+type (
+	// Limiter rate limits events per key.
+	Limiter[K comparable] interface {
+		// Allow records an event for key if one is allowed now
+		Allow(K) bool
+		// Reserve records an event for key, returning how long to wait
+		// before acting on it
+		Reserve(K) time.Duration
+		// Wait blocks until an event for key is allowed and records it
+		Wait(context.Context, K) error
+		// Delay returns how long until an event for key is allowed
+		Delay(K) time.Duration
+		// Len returns the number of keys tracked
+		Len() int
+	}
+
+	// limiter tracks a limit state per key. Keys are tracked in a TMap by
+	// when they were last used, so idle keys and, past maxKeys, the least
+	// recently used keys are evicted.
+	limiter[K comparable, S limitState] struct {
+		states   map[K]S
+		seen     TMap[K]
+		newState func() S
+		idle     time.Duration
+		maxKeys  int
+		clock    Clock
+		mutex    sync.Mutex
+	}
+	// limitState is the limit of one key
+	limitState interface {
+		// delay returns how long until an event is allowed
+		delay(now time.Time) time.Duration
+		// take records an event at, which is not before now
+		take(now, at time.Time)
+		// idle reports whether the state is the same as a new one
+		idle(now time.Time) bool
+	}
+
+	// windowLog is a sliding-window log, the times of the events in the
+	// last window, reserved ones included, in order
+	windowLog struct {
+		limit  int
+		window time.Duration
+		log    []time.Time
+	}
+	// bucket is a token bucket kept as the theoretical arrival time of
+	// the next event (GCRA), it refills one token every interval up to
+	// burst tokens
+	bucket struct {
+		every time.Duration
+		burst time.Duration
+		tat   time.Time
+	}
+)
+
+// NewSlidingWindow returns a limiter allowing limit events per key in any
+// window of time, tracking at most maxKeys keys if maxKeys is positive.
+func NewSlidingWindow[K comparable](limit int, window time.Duration, maxKeys int, opts ...Option) (Limiter[K], error) {
+	if limit <= 0 || window <= 0 {
+		return nil, fmt.Errorf("sliding window: must provide a positive limit and window")
+	}
+	return newLimiter[K](window, maxKeys, opts, func() *windowLog {
+		return &windowLog{limit: limit, window: window}
+	}), nil
+}
+
+// NewTokenBucket returns a limiter allowing bursts of burst events per key,
+// refilled at one event every interval, tracking at most maxKeys keys if
+// maxKeys is positive.
+func NewTokenBucket[K comparable](burst int, every time.Duration, maxKeys int, opts ...Option) (Limiter[K], error) {
+	if burst <= 0 || every <= 0 {
+		return nil, fmt.Errorf("token bucket: must provide a positive burst and interval")
+	}
+	return newLimiter[K](time.Duration(burst)*every, maxKeys, opts, func() *bucket {
+		return &bucket{every: every, burst: time.Duration(burst-1) * every}
+	}), nil
+}
+
+// newLimiter returns a limiter whose keys are idle after idle.
+func newLimiter[K comparable, S limitState](idle time.Duration, maxKeys int, opts []Option, newState func() S) *limiter[K, S] {
+	o := newOptions(opts)
+	return &limiter[K, S]{
+		states:   map[K]S{},
+		seen:     NewTMap[K](0, DontDrop, WithClock(o.clock)),
+		newState: newState,
+		idle:     idle,
+		maxKeys:  maxKeys,
+		clock:    o.clock,
+	}
+}
+
+// state returns the state of key, creating it, and evicts idle keys.
+// It must be called with the lock held.
+func (l *limiter[K, S]) state(key K, now time.Time) S {
+	s, ok := l.states[key]
+	if !ok {
+		s = l.newState()
+		l.states[key] = s
+	}
+	l.seen.Add(key)
+	l.evict(now, key)
+	return s
+}
+
+// evict removes idle keys, and the least recently used keys while there
+// are more than maxKeys, but never keep.
+func (l *limiter[K, S]) evict(now time.Time, keep K) {
+	for {
+		k, t, ok := l.seen.Oldest()
+		if !ok || k == keep {
+			return
+		}
+		full := l.maxKeys > 0 && len(l.states) > l.maxKeys
+		if !full && now.Sub(t) < l.idle {
+			return
+		}
+		if !full && !l.states[k].idle(now) {
+			// reserved ahead, look again later
+			l.seen.Add(k)
+			continue
+		}
+		l.seen.Delete(k)
+		delete(l.states, k)
+	}
+}
+
+func (l *limiter[K, S]) Allow(key K) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	s := l.state(key, now)
+	if s.delay(now) > 0 {
+		return false
+	}
+	s.take(now, now)
+	return true
+}
+
+func (l *limiter[K, S]) Reserve(key K) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	s := l.state(key, now)
+	d := s.delay(now)
+	s.take(now, now.Add(d))
+	return d
+}
+
+func (l *limiter[K, S]) Delay(key K) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	if s, ok := l.states[key]; ok {
+		return s.delay(now)
+	}
+	return 0
+}
+
+// Wait returns ErrWait at once if the context deadline comes before the
+// key would be allowed, and the context error if it ends while waiting.
+func (l *limiter[K, S]) Wait(ctx context.Context, key K) error {
+	for {
+		if l.Allow(key) {
+			return nil
+		}
+		d := l.Delay(key)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(l.clock.Now().Add(d)) {
+			return ErrWait
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.clock.After(d):
+		}
+	}
+}
+
+func (l *limiter[K, S]) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.states)
+}
+
+// prune drops the events that left the window.
+func (w *windowLog) prune(now time.Time) {
+	start := now.Add(-w.window)
+	i := sort.Search(len(w.log), func(i int) bool { return w.log[i].After(start) })
+	w.log = append(w.log[:0], w.log[i:]...)
+}
+
+func (w *windowLog) delay(now time.Time) time.Duration {
+	w.prune(now)
+	if len(w.log) < w.limit {
+		return 0
+	}
+	// the event limit places back frees a slot when it leaves the window
+	if d := w.log[len(w.log)-w.limit].Add(w.window).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (w *windowLog) take(now, at time.Time) {
+	w.prune(now)
+	i := sort.Search(len(w.log), func(i int) bool { return w.log[i].After(at) })
+	w.log = append(w.log, time.Time{})
+	copy(w.log[i+1:], w.log[i:])
+	w.log[i] = at
+}
+
+func (w *windowLog) idle(now time.Time) bool {
+	w.prune(now)
+	return len(w.log) == 0
+}
+
+func (b *bucket) delay(now time.Time) time.Duration {
+	if b.idle(now) {
+		return 0
+	}
+	if d := b.tat.Sub(now) - b.burst; d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (b *bucket) take(now, at time.Time) {
+	if b.tat.Before(at) {
+		b.tat = at
+	}
+	b.tat = b.tat.Add(b.every)
+}
+
+func (b *bucket) idle(now time.Time) bool {
+	return !b.tat.After(now)
+}
+
+// ClientIP keys a request by the IP address of the client.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// LimitHandler wraps next, answering 429 Too Many Requests with a
+// Retry-After header when the key of a request is over its limit. Key
+// requests with ClientIP, or with an identity the application checks
+// itself. Peers of pkg/mtls share a CA and make a new key pair for every
+// client, so their certificates do not tell them apart for long: a peer
+// would start over by making a new client.
+func LimitHandler(l Limiter[string], key func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if !l.Allow(k) {
+			secs := (l.Delay(k) + time.Second - 1) / time.Second
+			w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+package time
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	if _, err := NewSlidingWindow[string](0, time.Second, 0); err == nil {
+		t.Fatalf("should fail with zero limit")
+	}
+	l, err := NewSlidingWindow[string](3, time.Second, 0, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("event %d should be allowed", i)
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	if l.Allow("a") {
+		t.Fatalf("fourth event should not be allowed")
+	}
+	if !l.Allow("b") {
+		t.Fatalf("keys should be limited apart")
+	}
+	// the first event leaves the window at 1s
+	if d := l.Delay("a"); d != 700*time.Millisecond {
+		t.Fatalf("bad delay: %v", d)
+	}
+	if d := l.Reserve("a"); d != 700*time.Millisecond {
+		t.Fatalf("bad reserve: %v", d)
+	}
+	// the second at 1.1s, the reserved one holds a slot until 2s
+	if d := l.Reserve("a"); d != 800*time.Millisecond {
+		t.Fatalf("bad reserve: %v", d)
+	}
+	clock.Advance(800 * time.Millisecond)
+	if l.Allow("a") {
+		t.Fatalf("reserved slots should be taken")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Fatalf("third slot should be free")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	if _, err := NewTokenBucket[string](1, 0, 0); err == nil {
+		t.Fatalf("should fail with zero interval")
+	}
+	l, err := NewTokenBucket[string](3, 100*time.Millisecond, 0, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("burst event %d should be allowed", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatalf("empty bucket should not allow")
+	}
+	if d := l.Delay("a"); d != 100*time.Millisecond {
+		t.Fatalf("bad delay: %v", d)
+	}
+	if d := l.Reserve("a"); d != 100*time.Millisecond {
+		t.Fatalf("bad reserve: %v", d)
+	}
+	if d := l.Reserve("a"); d != 200*time.Millisecond {
+		t.Fatalf("bad reserve: %v", d)
+	}
+	clock.Advance(250 * time.Millisecond)
+	if l.Allow("a") {
+		t.Fatalf("refilled tokens should be reserved")
+	}
+	clock.Advance(50 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Fatalf("a token should be refilled")
+	}
+	// a full refill never holds more than burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow("a")
+	}
+	if l.Allow("a") {
+		t.Fatalf("bucket should hold at most burst tokens")
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l, err := NewTokenBucket[string](1, time.Second, 0, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := l.Wait(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithDeadline(ctx, clock.Now().Add(time.Millisecond))
+	defer cancel()
+	if err := l.Wait(short, "a"); err != ErrWait {
+		t.Fatalf("should not wait past the deadline: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(ctx, "a")
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	go func() {
+		done <- l.Wait(canceled, "a")
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("should end with the context: %v", err)
+	}
+}
+
+func TestLimiter_Evict(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l, err := NewSlidingWindow[int](1, time.Second, 3, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Allow(i)
+	}
+	if l.Len() != 3 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	// 0 was evicted, so it is allowed again
+	if !l.Allow(0) {
+		t.Fatalf("evicted key should be new")
+	}
+	if l.Allow(4) {
+		t.Fatalf("recent key should be kept")
+	}
+	// idle keys are dropped by the next use
+	l.Reserve(3)
+	clock.Advance(time.Second)
+	l.Allow(9)
+	if l.Len() != 2 {
+		t.Fatalf("idle keys should be evicted: %v", l.Len())
+	}
+	if l.Allow(3) {
+		t.Fatalf("key with a reservation should be kept")
+	}
+}
+
+func TestLimitHandler(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l, err := NewSlidingWindow[string](1, 1500*time.Millisecond, 0, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := []string{}
+	h := LimitHandler(l, ClientIP, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, ClientIP(r))
+	}))
+	get := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", w.Code)
+	}
+	// same ip, other port
+	w := get("10.0.0.1:4321")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("bad status: %v", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "2" {
+		t.Fatalf("bad retry after: %q", ra)
+	}
+	// other ips are limited apart
+	if w := get("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", w.Code)
+	}
+	if w := get("[::1]:1234"); w.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", w.Code)
+	}
+	clock.Advance(1500 * time.Millisecond)
+	if w := get("10.0.0.1:4321"); w.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", w.Code)
+	}
+	if len(seen) != 4 || seen[0] != "10.0.0.1" || seen[2] != "::1" {
+		t.Fatalf("bad keys: %v", seen)
+	}
+}
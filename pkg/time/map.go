@@ -1,29 +1,73 @@
 package time
 
 import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
 	"sync"
 	"time"
 )
 
+const (
+	// minSweepInterval bounds how often the sweeper may wake up
+	minSweepInterval = time.Millisecond
+)
+
+var (
+	// ErrFull is returned by Add when a map that does not drop live keys
+	// is at capacity
+	ErrFull = fmt.Errorf("tmap: full")
+)
+
 type (
 	// TMap
 	TMap[T comparable] interface {
 		Size() int
-		Add(T) time.Time
+		Add(T) (time.Time, error)
+		AddWait(context.Context, T) (time.Time, error)
 		Capacity(...int) int
 		Get(T) (time.Time, bool)
 		Delete(T) (time.Time, bool)
 		Newest() (T, time.Time, bool)
 		Oldest() (T, time.Time, bool)
 		Each(func(T, time.Time) error) error
+		MaxAge(...time.Duration) time.Duration
+		OnExpire(func(T, time.Time))
+		Expire() int
+		Sweep(context.Context, time.Duration)
+		json.Marshaler
+		json.Unmarshaler
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
 	}
 
 	// tMap
 	tMap[T comparable] struct {
+		tCore[T, struct{}]
+	}
+	// tCore holds the keys of a TMap or a TMapKV, each with the time it
+	// was added and a value, and does what both share: expiry, capacity
+	// and dropping. Its unexported methods must be called with the lock
+	// held.
+	tCore[T comparable, E any] struct {
 		capacity int
 		policy   DropPolicy
+		maxAge   time.Duration
+		onExpire func(T, E, time.Time)
 		mutex    sync.RWMutex
-		M        map[T]time.Time `json:"map"`
+		M        map[T]tEntry[E] `json:"map"`
+		index    *tIndex[T]
+		// accessed orders keys by last use, nil if only adding uses them
+		accessed *tIndex[T]
+		clock    Clock
+		seq      uint64
+		freed    chan struct{}
+	}
+	// tEntry is the time a key was added and its value
+	tEntry[E any] struct {
+		t time.Time
+		v E
 	}
 	// DropPolicy
 	DropPolicy int
@@ -31,47 +75,57 @@ type (
 
 const (
 	unknown DropPolicy = iota
+	// DontDrop rejects new keys when full
 	DontDrop
+	// DropRandom drops any key but the one just added
 	DropRandom
 	DropOldest
 	DropNewest
+	// DropLeastRecentlyAccessed drops the key used longest ago, a TMap only
+	// uses keys by adding them so it drops the oldest
+	DropLeastRecentlyAccessed
+	// DropExpired only drops expired keys to make room, and rejects new
+	// keys when there are none
+	DropExpired
 )
 
-// Add
-func (s *tMap[T]) Add(v T) time.Time {
-	t := time.Now().UTC()
+// Add returns ErrFull if the map is full and its policy does not drop
+// live keys, adding a key already present always succeeds
+func (s *tMap[T]) Add(v T) (time.Time, error) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	s.M[v] = t
-	s.drop()
-	return t
+	t, vs, es, err := s.add(v, struct{}{})
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, vs, es)
+	return t, err
 }
 
-// drop
-func (s *tMap[T]) drop() {
-	// if size is bigger than cap, trim
-	size := len(s.M)
-	capacity := s.capacity
-	if (size > capacity) && (capacity != 0) {
-		for i := 0; i < (size - capacity); i++ {
-			switch s.policy {
-			case DropOldest:
-				v, _, ok := s.oldest()
-				if ok {
-					s.delete(v)
-				}
-			case DropNewest:
-				v, _, ok := s.newest()
-				if ok {
-					s.delete(v)
-				}
-			case DropRandom:
-				for v := range s.M {
-					delete(s.M, v)
-					break
-				}
+// AddWait adds v, waiting while the map is full for a Delete, an expiry
+// or a larger capacity to make room, until ctx is done.
+func (s *tMap[T]) AddWait(ctx context.Context, v T) (time.Time, error) {
+	for {
+		var freed <-chan struct{}
+		var expiry <-chan time.Time
+		s.mutex.Lock()
+		t, vs, es, err := s.add(v, struct{}{})
+		if err == ErrFull {
+			freed = s.wait()
+			if it := s.index.oldest(); it != nil && s.maxAge > 0 {
+				expiry = s.clock.After(it.t.Add(s.maxAge).Sub(s.clock.Now()))
 			}
 		}
+		onExpire := s.onExpire
+		s.mutex.Unlock()
+		notify(onExpire, vs, es)
+		if err != ErrFull {
+			return t, err
+		}
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-freed:
+		case <-expiry:
+		}
 	}
 }
 
@@ -79,97 +133,225 @@ func (s *tMap[T]) drop() {
 func (s *tMap[T]) Get(v T) (time.Time, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	t, ok := s.M[v]
-	return t, ok
+	e, ok := s.get(v)
+	return e.t, ok
 }
 
 // Delete
 func (s *tMap[T]) Delete(v T) (time.Time, bool) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	return s.delete(v)
+	e, ok := s.delete(v)
+	return e.t, ok
 }
 
-// delete
-func (s *tMap[T]) delete(v T) (time.Time, bool) {
-	t, ok := s.M[v]
-	delete(s.M, v)
-	return t, ok
-}
-
-// Oldest
-func (s *tMap[T]) Oldest() (v T, t time.Time, ok bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return s.oldest()
-}
-
-// oldest
-func (s *tMap[T]) oldest() (v T, t time.Time, ok bool) {
-	t = time.Now().UTC()
-	s.each(
-		func(tc T, tt time.Time) error {
-			if tt.Before(t) {
-				t = tt
-				v = tc
-				ok = true
-			}
-			return nil
-		},
-	)
-	return v, t, ok
+// Oldest removes expired keys first, they would be the oldest
+func (s *tMap[T]) Oldest() (T, time.Time, bool) {
+	v, e, ok := s.first()
+	return v, e.t, ok
 }
 
 // Newest
-func (s *tMap[T]) Newest() (v T, t time.Time, ok bool) {
+func (s *tMap[T]) Newest() (T, time.Time, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return s.newest()
-}
-
-// newest
-func (s *tMap[T]) newest() (v T, t time.Time, ok bool) {
-	t = time.Time{}
-	s.each(
-		func(tc T, tt time.Time) error {
-			if tt.After(t) {
-				t = tt
-				v = tc
-				ok = true
-			}
-			return nil
-		},
-	)
-	return v, t, ok
+	v, e, ok := s.newest()
+	return v, e.t, ok
 }
 
 // Each
 func (s *tMap[T]) Each(f func(T, time.Time) error) error {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return s.each(f)
+	return s.each(func(v T, e tEntry[struct{}]) error {
+		return f(v, e.t)
+	})
+}
+
+// OnExpire sets a callback for every expired key removed from the map,
+// it is called without the lock held.
+func (s *tMap[T]) OnExpire(f func(T, time.Time)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onExpire = nil
+	if f != nil {
+		s.onExpire = func(v T, _ struct{}, t time.Time) {
+			f(v, t)
+		}
+	}
+}
+
+// add sets the value of v, it returns the expired keys removed to make
+// room.
+func (s *tCore[T, E]) add(v T, e E) (t time.Time, vs []T, es []tEntry[E], err error) {
+	t = s.clock.Now().UTC()
+	_, ok := s.M[v]
+	if s.capacity != 0 && !ok && len(s.M) >= s.capacity {
+		// make room with expired keys before dropping live ones
+		vs, es = s.expire(t)
+		if len(s.M) >= s.capacity && (s.policy == DontDrop || s.policy == DropExpired) {
+			return time.Time{}, vs, es, ErrFull
+		}
+	}
+	s.M[v] = tEntry[E]{t: t, v: e}
+	s.seq++
+	s.index.set(v, t, s.seq)
+	if s.accessed != nil {
+		s.accessed.set(v, t, s.seq)
+	}
+	s.drop(v)
+	return t, vs, es, nil
+}
+
+// wait returns a channel closed when a key is removed or the capacity
+// changes.
+func (s *tCore[T, E]) wait() <-chan struct{} {
+	if s.freed == nil {
+		s.freed = make(chan struct{})
+	}
+	return s.freed
+}
+
+// free wakes the AddWait callers.
+func (s *tCore[T, E]) free() {
+	if s.freed != nil {
+		close(s.freed)
+		s.freed = nil
+	}
+}
+
+// drop trims the map to capacity by its policy, never dropping keep, the
+// key just added, at random
+func (s *tCore[T, E]) drop(keep ...T) {
+	if s.capacity == 0 {
+		return
+	}
+	for n := len(s.M) - s.capacity; n > 0; n-- {
+		var it *tItem[T]
+		switch s.policy {
+		case DropOldest:
+			it = s.index.oldest()
+		case DropNewest:
+			it = s.index.newest()
+		case DropLeastRecentlyAccessed:
+			if it = s.index.oldest(); s.accessed != nil {
+				it = s.accessed.oldest()
+			}
+		case DropRandom:
+			for v := range s.M {
+				if len(keep) == 0 || v != keep[0] {
+					s.delete(v)
+					break
+				}
+			}
+		}
+		if it != nil {
+			s.delete(it.v)
+		}
+	}
+}
+
+// trim drops the keys over capacity by the policy, DontDrop and
+// DropExpired drop the newest keys, the ones a full map would have
+// rejected
+func (s *tCore[T, E]) trim() {
+	if s.policy == DontDrop || s.policy == DropExpired {
+		for s.capacity != 0 && len(s.M) > s.capacity {
+			s.delete(s.index.newest().v)
+		}
+	}
+	s.drop()
+}
+
+// get returns the entry of v, if present and not expired
+func (s *tCore[T, E]) get(v T) (tEntry[E], bool) {
+	e, ok := s.M[v]
+	if !ok || s.expired(e.t, s.clock.Now()) {
+		return tEntry[E]{}, false
+	}
+	return e, true
+}
+
+// delete returns the entry of v if it was not expired
+func (s *tCore[T, E]) delete(v T) (tEntry[E], bool) {
+	e, ok := s.M[v]
+	if !ok {
+		return e, false
+	}
+	delete(s.M, v)
+	s.index.remove(v)
+	if s.accessed != nil {
+		s.accessed.remove(v)
+	}
+	s.free()
+	if s.expired(e.t, s.clock.Now()) {
+		return tEntry[E]{}, false
+	}
+	return e, true
 }
 
-// each
-func (s *tMap[T]) each(f func(T, time.Time) error) error {
-	for element, addedAt := range s.M {
-		err := f(element, addedAt)
-		if err != nil {
+// first takes the lock and removes expired keys, they would be the
+// oldest, before returning the oldest
+func (s *tCore[T, E]) first() (v T, e tEntry[E], ok bool) {
+	s.mutex.Lock()
+	vs, es := s.expire(s.clock.Now())
+	v, e, ok = s.oldest()
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, vs, es)
+	return v, e, ok
+}
+
+// oldest is O(1), it must be called with no expired keys
+func (s *tCore[T, E]) oldest() (v T, e tEntry[E], ok bool) {
+	if it := s.index.oldest(); it != nil {
+		return it.v, s.M[it.v], true
+	}
+	return v, e, false
+}
+
+// newest is O(1), if the newest key expired they all did
+func (s *tCore[T, E]) newest() (v T, e tEntry[E], ok bool) {
+	if it := s.index.newest(); it != nil && !s.expired(it.t, s.clock.Now()) {
+		return it.v, s.M[it.v], true
+	}
+	return v, e, false
+}
+
+// each skips expired keys
+func (s *tCore[T, E]) each(f func(T, tEntry[E]) error) error {
+	now := s.clock.Now()
+	for v, e := range s.M {
+		if s.expired(e.t, now) {
+			continue
+		}
+		if err := f(v, e); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Size
-func (s *tMap[T]) Size() int {
+// Size removes expired keys first
+func (s *tCore[T, E]) Size() int {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return len(s.M)
+	if s.maxAge == 0 {
+		defer s.mutex.RUnlock()
+		return len(s.M)
+	}
+	s.mutex.RUnlock()
+	s.mutex.Lock()
+	vs, es := s.expire(s.clock.Now())
+	size := len(s.M)
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, vs, es)
+	return size
 }
 
-// Capacity
-func (s *tMap[T]) Capacity(c ...int) int {
+// Capacity, a smaller capacity expires and then drops keys as Add would
+// to fit
+func (s *tCore[T, E]) Capacity(c ...int) int {
 	switch len(c) {
 	case 0:
 		s.mutex.RLock()
@@ -177,19 +359,127 @@ func (s *tMap[T]) Capacity(c ...int) int {
 		return s.capacity
 	case 1:
 		s.mutex.Lock()
-		defer s.mutex.Unlock()
 		s.capacity = c[0]
-		return s.capacity
+		var vs []T
+		var es []tEntry[E]
+		if s.capacity != 0 && len(s.M) > s.capacity {
+			// make room with expired keys before dropping live ones
+			vs, es = s.expire(s.clock.Now())
+			s.trim()
+		}
+		s.free()
+		onExpire := s.onExpire
+		s.mutex.Unlock()
+		notify(onExpire, vs, es)
+		return c[0]
+	default:
+		return -1
+	}
+}
+
+// MaxAge gets or sets how long keys live after they are added, zero
+// keeps them until dropped. Expired keys are hidden at once and removed
+// by Expire, Sweep, Size, Oldest or an Add over capacity.
+func (s *tCore[T, E]) MaxAge(d ...time.Duration) time.Duration {
+	switch len(d) {
+	case 0:
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		return s.maxAge
+	case 1:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if d[0] < 0 {
+			d[0] = 0
+		}
+		s.maxAge = d[0]
+		return s.maxAge
 	default:
 		return -1
 	}
 }
 
-// NewTMap
-func NewTMap[T comparable](capacity int, policy DropPolicy) TMap[T] {
+// Expire removes the expired keys, returning how many were removed.
+func (s *tCore[T, E]) Expire() int {
+	s.mutex.Lock()
+	vs, es := s.expire(s.clock.Now())
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, vs, es)
+	return len(vs)
+}
+
+// expire removes the keys expired by now, oldest first, returning them.
+func (s *tCore[T, E]) expire(now time.Time) (vs []T, es []tEntry[E]) {
+	if s.maxAge == 0 {
+		return nil, nil
+	}
+	for {
+		it := s.index.oldest()
+		if it == nil || !s.expired(it.t, now) {
+			if len(vs) > 0 {
+				s.free()
+			}
+			return vs, es
+		}
+		vs = append(vs, it.v)
+		es = append(es, s.M[it.v])
+		delete(s.M, it.v)
+		s.index.remove(it.v)
+		if s.accessed != nil {
+			s.accessed.remove(it.v)
+		}
+	}
+}
+
+// expired reports whether a key added at t has expired by now.
+func (s *tCore[T, E]) expired(t, now time.Time) bool {
+	return s.maxAge > 0 && now.Sub(t) >= s.maxAge
+}
+
+// notify calls f for expired keys, it must be called without the lock held.
+func notify[T comparable, E any](f func(T, E, time.Time), vs []T, es []tEntry[E]) {
+	if f == nil {
+		return
+	}
+	for i := range vs {
+		f(vs[i], es[i].v, es[i].t)
+	}
+}
+
+// Sweep starts removing expired keys every interval until ctx is done.
+// A zero interval sweeps at half the max age, and not at all without one.
+func (s *tCore[T, E]) Sweep(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		if interval = s.MaxAge() / 2; interval == 0 {
+			return
+		}
+	}
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.clock.After(interval):
+				s.Expire()
+			}
+		}
+	}()
+}
+
+// NewTMap, keys added at the same time are ordered by when they were added
+func NewTMap[T comparable](capacity int, policy DropPolicy, opts ...Option) TMap[T] {
+	o := newOptions(opts)
 	return &tMap[T]{
-		policy:   policy,
-		capacity: capacity,
-		M:        make(map[T]time.Time),
+		tCore: tCore[T, struct{}]{
+			policy:   policy,
+			capacity: capacity,
+			M:        make(map[T]tEntry[struct{}]),
+			index:    newTIndex[T](),
+			clock:    o.clock,
+		},
 	}
 }
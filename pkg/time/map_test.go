@@ -0,0 +1,484 @@
+package time
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTMap_MaxAge(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewTMap[int](0, DropOldest, WithClock(clock))
+	if m.MaxAge() != 0 || m.MaxAge(-time.Second) != 0 {
+		t.Fatalf("bad max age: %v", m.MaxAge())
+	}
+	expired := map[int]time.Time{}
+	m.OnExpire(func(v int, at time.Time) {
+		expired[v] = at
+	})
+	added, _ := m.Add(1)
+	m.Add(2)
+	m.MaxAge(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+	m.Add(3)
+
+	if _, ok := m.Get(1); ok {
+		t.Fatalf("1 should be expired")
+	}
+	if _, ok := m.Get(3); !ok {
+		t.Fatalf("3 should not be expired")
+	}
+	keys := []int{}
+	m.Each(func(v int, _ time.Time) error {
+		keys = append(keys, v)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != 3 {
+		t.Fatalf("bad keys: %v", keys)
+	}
+	if v, _, _ := m.Newest(); v != 3 {
+		t.Fatalf("bad newest: %v", v)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expired keys should not be removed yet: %v", expired)
+	}
+	if n := m.Expire(); n != 2 {
+		t.Fatalf("bad expire count: %v", n)
+	}
+	if len(expired) != 2 || !expired[1].Equal(added) {
+		t.Fatalf("bad expired: %v", expired)
+	}
+	if m.Size() != 1 {
+		t.Fatalf("bad size: %v", m.Size())
+	}
+	if v, _, _ := m.Oldest(); v != 3 {
+		t.Fatalf("bad oldest: %v", v)
+	}
+	// a longer max age brings nothing back
+	m.MaxAge(time.Hour)
+	if m.Size() != 1 {
+		t.Fatalf("bad size: %v", m.Size())
+	}
+}
+
+func TestTMap_MaxAgeCapacity(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewTMap[int](2, DropNewest, WithClock(clock))
+	m.MaxAge(20 * time.Millisecond)
+	expired := 0
+	m.OnExpire(func(int, time.Time) {
+		expired++
+	})
+	m.Add(1)
+	m.Add(2)
+	clock.Advance(time.Second)
+	// expired keys make room before live ones are dropped
+	m.Add(3)
+	m.Add(4)
+	if expired != 2 || m.Size() != 2 {
+		t.Fatalf("bad expired: %v, size %v", expired, m.Size())
+	}
+	m.Add(5)
+	if _, ok := m.Get(5); ok || m.Size() != 2 {
+		t.Fatalf("newest should be dropped")
+	}
+}
+
+func TestTMap_Sweep(t *testing.T) {
+	m := NewTMap[string](0, DropOldest)
+	m.MaxAge(10 * time.Millisecond)
+	var mu sync.Mutex
+	expired := []string{}
+	m.OnExpire(func(v string, _ time.Time) {
+		mu.Lock()
+		expired = append(expired, v)
+		mu.Unlock()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Sweep(ctx, time.Millisecond)
+	m.Add("a")
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Fatalf("sweeper should expire a: %v", expired)
+	}
+	mu.Unlock()
+
+	cancel()
+	time.Sleep(5 * time.Millisecond)
+	m.Add("b")
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 1 {
+		t.Fatalf("stopped sweeper should not expire: %v", expired)
+	}
+	if _, ok := m.Get("b"); ok {
+		t.Fatalf("b should still be hidden")
+	}
+
+	// without a max age there is nothing to sweep
+	clock := NewManualClock(time.Unix(0, 0))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	m = NewTMap[string](0, DropOldest, WithClock(clock))
+	m.Sweep(ctx, 0)
+	NewTMapKV[string, int](0, DropOldest, WithClock(clock)).Sweep(ctx, 0)
+	time.Sleep(5 * time.Millisecond)
+	if n := clock.Waiters(); n != 0 {
+		t.Fatalf("sweeper should not start: %v waiters", n)
+	}
+}
+
+func TestTMap_Drop(t *testing.T) {
+	for _, policy := range []DropPolicy{DropOldest, DropNewest, DropRandom, DontDrop} {
+		m := NewTMap[int](100, policy)
+		for i := 0; i < 1000; i++ {
+			m.Add(i % 300)
+			if i%7 == 0 {
+				m.Delete(i % 50)
+			}
+			// oldest and newest match a scan of the map
+			var min, max time.Time
+			m.Each(func(_ int, at time.Time) error {
+				if min.IsZero() || at.Before(min) {
+					min = at
+				}
+				if at.After(max) {
+					max = at
+				}
+				return nil
+			})
+			_, oldest, ok := m.Oldest()
+			if ok != !min.IsZero() || !oldest.Equal(min) {
+				t.Fatalf("%v: bad oldest: %v, want %v", policy, oldest, min)
+			}
+			_, newest, _ := m.Newest()
+			if !newest.Equal(max) {
+				t.Fatalf("%v: bad newest: %v, want %v", policy, newest, max)
+			}
+		}
+		if size := m.Size(); size != 100 {
+			t.Fatalf("%v: bad size: %v", policy, size)
+		}
+	}
+
+	m := NewTMap[int](2, DropOldest)
+	m.Add(1)
+	m.Add(2)
+	m.Add(1) // moves 1 after 2
+	m.Add(3)
+	if _, ok := m.Get(2); ok {
+		t.Fatalf("2 should be dropped")
+	}
+	m = NewTMap[int](2, DropNewest)
+	m.Add(1)
+	m.Add(2)
+	m.Add(3)
+	if _, ok := m.Get(3); ok || m.Size() != 2 {
+		t.Fatalf("3 should be dropped")
+	}
+}
+
+func TestTMap_DontDrop(t *testing.T) {
+	m := NewTMap[int](2, DontDrop)
+	m.Add(1)
+	m.Add(2)
+	if _, err := m.Add(3); err != ErrFull {
+		t.Fatalf("should be full: %v", err)
+	}
+	if _, err := m.Add(1); err != nil {
+		t.Fatalf("present keys should be added: %v", err)
+	}
+	m.Delete(2)
+	if _, err := m.Add(3); err != nil {
+		t.Fatal(err)
+	}
+	m.Capacity(3)
+	if _, err := m.Add(4); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 3 {
+		t.Fatalf("bad size: %v", m.Size())
+	}
+	// a smaller capacity drops the keys it would have rejected
+	m.Capacity(2)
+	if _, ok := m.Get(4); ok || m.Size() != 2 {
+		t.Fatalf("4 should be dropped: %v", m.Size())
+	}
+	if _, err := m.Add(5); err != ErrFull {
+		t.Fatalf("should be full: %v", err)
+	}
+}
+
+func TestTMap_AddWait(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewTMap[int](1, DontDrop, WithClock(clock))
+	ctx := context.Background()
+	if _, err := m.AddWait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := m.AddWait(ctx, 2)
+		done <- err
+	}()
+	// a delete makes room
+	waiting := func() bool {
+		s := m.(*tMap[int])
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.freed != nil
+	}
+	for !waiting() {
+		time.Sleep(time.Millisecond)
+	}
+	m.Delete(1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Get(2); !ok {
+		t.Fatalf("2 should be added")
+	}
+
+	// so does an expiry
+	m.MaxAge(time.Second)
+	go func() {
+		_, err := m.AddWait(ctx, 3)
+		done <- err
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Get(3); !ok || m.Size() != 1 {
+		t.Fatalf("3 should replace 2")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.AddWait(canceled, 4); err != context.Canceled {
+		t.Fatalf("should end with the context: %v", err)
+	}
+}
+
+func BenchmarkTMap_DropOldest(b *testing.B) {
+	benchmarkDrop(b, DropOldest)
+}
+
+func BenchmarkTMap_DropNewest(b *testing.B) {
+	benchmarkDrop(b, DropNewest)
+}
+
+func BenchmarkTMap_DropRandom(b *testing.B) {
+	benchmarkDrop(b, DropRandom)
+}
+
+// benchmarkDrop adds distinct keys to a full map
+func benchmarkDrop(b *testing.B, policy DropPolicy) {
+	m := NewTMap[int](8192, policy)
+	for i := 0; i < 8192; i++ {
+		m.Add(-i - 1)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.Add(i)
+	}
+}
+
+func BenchmarkTMap_Oldest(b *testing.B) {
+	m := NewTMap[int](0, DropOldest)
+	for i := 0; i < 1<<16; i++ {
+		m.Add(i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.Oldest()
+		m.Newest()
+	}
+}
+
+func TestTMap_Clock(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	m := NewTMap[string](3, DropOldest, WithClock(clock))
+
+	// keys added at the same time keep their order
+	for _, v := range []string{"a", "b", "c"} {
+		if at, _ := m.Add(v); !at.Equal(time.Unix(100, 0)) {
+			t.Fatalf("bad time: %v", at)
+		}
+	}
+	if v, _, _ := m.Oldest(); v != "a" {
+		t.Fatalf("bad oldest: %v", v)
+	}
+	if v, _, _ := m.Newest(); v != "c" {
+		t.Fatalf("bad newest: %v", v)
+	}
+	m.Add("a")
+	m.Add("d")
+	if _, ok := m.Get("b"); ok {
+		t.Fatalf("b should be dropped")
+	}
+	// a clock going backwards makes the new key the oldest
+	clock.Set(time.Unix(50, 0))
+	m.Capacity(4)
+	m.Add("e")
+	if v, at, _ := m.Oldest(); v != "e" || !at.Equal(time.Unix(50, 0)) {
+		t.Fatalf("bad oldest: %v %v", v, at)
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("bad now: %v", clock.Now())
+	}
+	if at := <-clock.After(0); !at.Equal(start) {
+		t.Fatalf("zero After should fire at once: %v", at)
+	}
+	late := clock.After(2 * time.Second)
+	early := clock.After(time.Second)
+	if clock.Waiters() != 2 {
+		t.Fatalf("bad waiters: %v", clock.Waiters())
+	}
+	clock.Advance(time.Second)
+	select {
+	case at := <-early:
+		if !at.Equal(start.Add(time.Second)) {
+			t.Fatalf("bad time: %v", at)
+		}
+	default:
+		t.Fatalf("early should fire")
+	}
+	select {
+	case <-late:
+		t.Fatalf("late should not fire")
+	default:
+	}
+	clock.Advance(time.Hour)
+	if at := <-late; !at.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("bad time: %v", at)
+	}
+	if clock.Waiters() != 0 || !clock.Now().Equal(start.Add(time.Hour+time.Second)) {
+		t.Fatalf("bad clock: %v %v", clock.Waiters(), clock.Now())
+	}
+}
+
+func TestTMap_Marshal(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	m := NewTMap[string](4, DropOldest, WithClock(clock))
+	m.MaxAge(time.Hour)
+	for _, v := range []string{"a", "b", "c", "d"} {
+		m.Add(v)
+		clock.Advance(time.Second)
+	}
+	m.Add("a")
+
+	codecs := []struct {
+		name      string
+		marshal   func() ([]byte, error)
+		unmarshal func(TMap[string], []byte) error
+	}{
+		{"json", m.MarshalJSON, func(r TMap[string], b []byte) error {
+			return json.Unmarshal(b, r)
+		}},
+		{"binary", m.MarshalBinary, func(r TMap[string], b []byte) error {
+			return r.UnmarshalBinary(b)
+		}},
+	}
+	for _, c := range codecs {
+		b, err := c.marshal()
+		if err != nil {
+			t.Fatalf("%s: err: %v", c.name, err)
+		}
+		r := NewTMap[string](0, DontDrop, WithClock(clock))
+		if err := c.unmarshal(r, b); err != nil {
+			t.Fatalf("%s: err: %v", c.name, err)
+		}
+		if r.Capacity() != 4 || r.MaxAge() != time.Hour || r.Size() != 4 {
+			t.Fatalf("%s: bad settings: %v %v %v", c.name, r.Capacity(), r.MaxAge(), r.Size())
+		}
+		for _, v := range []string{"a", "b", "c", "d"} {
+			want, _ := m.Get(v)
+			if got, ok := r.Get(v); !ok || !got.Equal(want) {
+				t.Fatalf("%s: bad time of %v: %v, want %v", c.name, v, got, want)
+			}
+		}
+		// the order is kept, and so is the policy
+		if v, _, _ := r.Oldest(); v != "b" {
+			t.Fatalf("%s: bad oldest: %v", c.name, v)
+		}
+		r.Add("e")
+		if _, ok := r.Get("b"); ok {
+			t.Fatalf("%s: b should be dropped", c.name)
+		}
+
+		// a smaller capacity drops with the restored policy
+		b = bytes.Replace(b, []byte(`"capacity":4`), []byte(`"capacity":2`), 1)
+		if c.name == "json" {
+			if err := c.unmarshal(r, b); err != nil {
+				t.Fatalf("%s: err: %v", c.name, err)
+			}
+			if r.Size() != 2 {
+				t.Fatalf("%s: bad size: %v", c.name, r.Size())
+			}
+			if v, _, _ := r.Oldest(); v != "d" {
+				t.Fatalf("%s: bad oldest: %v", c.name, v)
+			}
+		}
+	}
+
+	// a DontDrop map keeps its oldest keys
+	b, _ := json.Marshal(map[string]any{
+		"version":  persistVersion,
+		"capacity": 2,
+		"policy":   DontDrop,
+		"map": []map[string]any{
+			{"key": "a", "time": clock.Now()},
+			{"key": "b", "time": clock.Now()},
+			{"key": "c", "time": clock.Now()},
+		},
+	})
+	r := NewTMap[string](0, DropOldest, WithClock(clock))
+	if err := json.Unmarshal(b, r); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := r.Get("c"); ok || r.Size() != 2 {
+		t.Fatalf("newest key over capacity should be trimmed: %v", r.Size())
+	}
+	if _, err := r.Add("d"); err != ErrFull {
+		t.Fatalf("should be full: %v", err)
+	}
+
+	// expired keys are not restored
+	b, _ = m.MarshalJSON()
+	clock.Advance(time.Hour - 2*time.Second)
+	expired := []string{}
+	r = NewTMap[string](0, DontDrop, WithClock(clock))
+	r.OnExpire(func(v string, _ time.Time) {
+		expired = append(expired, v)
+	})
+	if err := json.Unmarshal(b, r); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if r.Size() != 2 || len(expired) != 2 {
+		t.Fatalf("bad size: %v, expired %v", r.Size(), expired)
+	}
+	if err := json.Unmarshal([]byte(`{"version":2}`), r); err == nil {
+		t.Fatalf("should fail with an unknown version")
+	}
+	if err := r.UnmarshalBinary([]byte("junk")); err == nil {
+		t.Fatalf("should fail with junk")
+	}
+}
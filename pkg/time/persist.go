@@ -0,0 +1,150 @@
+package time
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// persistVersion is written in every marshaled TMap
+	persistVersion = 1
+)
+
+type (
+	// tMapJSON is the JSON form of a tMap, keys from oldest to newest
+	tMapJSON[T comparable] struct {
+		Version  int                `json:"version"`
+		Capacity int                `json:"capacity"`
+		Policy   DropPolicy         `json:"policy"`
+		MaxAge   time.Duration      `json:"maxAge,omitempty"`
+		M        []tMapEntryJSON[T] `json:"map"`
+	}
+	tMapEntryJSON[T comparable] struct {
+		Key  T         `json:"key"`
+		Time time.Time `json:"time"`
+	}
+	// tMapBinary is the gob form of a tMap, keys from oldest to newest
+	tMapBinary[T comparable] struct {
+		Version  int
+		Capacity int
+		Policy   DropPolicy
+		MaxAge   time.Duration
+		Keys     []T
+		Times    []time.Time
+	}
+)
+
+// sorted returns the items from oldest to newest.
+func (x *tIndex[T]) sorted() []*tItem[T] {
+	items := append([]*tItem[T]{}, x.min.items...)
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.t.Equal(b.t) {
+			return a.seq < b.seq
+		}
+		return a.t.Before(b.t)
+	})
+	return items
+}
+
+// MarshalJSON writes the capacity, policy, max age and keys with their
+// times, from oldest to newest.
+func (s *tMap[T]) MarshalJSON() ([]byte, error) {
+	s.mutex.RLock()
+	j := tMapJSON[T]{
+		Version:  persistVersion,
+		Capacity: s.capacity,
+		Policy:   s.policy,
+		MaxAge:   s.maxAge,
+		M:        make([]tMapEntryJSON[T], 0, len(s.M)),
+	}
+	for _, it := range s.index.sorted() {
+		j.M = append(j.M, tMapEntryJSON[T]{Key: it.v, Time: it.t})
+	}
+	s.mutex.RUnlock()
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON replaces the map with one written by MarshalJSON.
+func (s *tMap[T]) UnmarshalJSON(b []byte) error {
+	j := tMapJSON[T]{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if j.Version != persistVersion {
+		return fmt.Errorf("tmap: unknown version %d", j.Version)
+	}
+	vs := make([]T, len(j.M))
+	ts := make([]time.Time, len(j.M))
+	for i, e := range j.M {
+		vs[i], ts[i] = e.Key, e.Time
+	}
+	s.restore(j.Capacity, j.Policy, j.MaxAge, vs, ts)
+	return nil
+}
+
+// MarshalBinary writes the same as MarshalJSON with encoding/gob.
+func (s *tMap[T]) MarshalBinary() ([]byte, error) {
+	s.mutex.RLock()
+	g := tMapBinary[T]{
+		Version:  persistVersion,
+		Capacity: s.capacity,
+		Policy:   s.policy,
+		MaxAge:   s.maxAge,
+		Keys:     make([]T, 0, len(s.M)),
+		Times:    make([]time.Time, 0, len(s.M)),
+	}
+	for _, it := range s.index.sorted() {
+		g.Keys = append(g.Keys, it.v)
+		g.Times = append(g.Times, it.t)
+	}
+	s.mutex.RUnlock()
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the map with one written by MarshalBinary.
+func (s *tMap[T]) UnmarshalBinary(b []byte) error {
+	g := tMapBinary[T]{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g); err != nil {
+		return err
+	}
+	if g.Version != persistVersion {
+		return fmt.Errorf("tmap: unknown version %d", g.Version)
+	}
+	if len(g.Keys) != len(g.Times) {
+		return fmt.Errorf("tmap: %d keys but %d times", len(g.Keys), len(g.Times))
+	}
+	s.restore(g.Capacity, g.Policy, g.MaxAge, g.Keys, g.Times)
+	return nil
+}
+
+// restore replaces the settings and keys, adding keys in order so their
+// sequence is kept, then expires and trims keys over capacity as
+// Capacity would.
+func (s *tMap[T]) restore(capacity int, policy DropPolicy, maxAge time.Duration, vs []T, ts []time.Time) {
+	s.mutex.Lock()
+	s.capacity = capacity
+	s.policy = policy
+	s.maxAge = maxAge
+	s.M = make(map[T]tEntry[struct{}], len(vs))
+	s.index = newTIndex[T]()
+	for i, v := range vs {
+		s.M[v] = tEntry[struct{}]{t: ts[i]}
+		s.seq++
+		s.index.set(v, ts[i], s.seq)
+	}
+	evs, ees := s.expire(s.clock.Now())
+	s.trim()
+	s.free()
+	onExpire := s.onExpire
+	s.mutex.Unlock()
+	notify(onExpire, evs, ees)
+}